@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// checkpoint is the progress of a historical generation, saved to the "checkpoint-file" to be resumed later.
+// Each worker saves its state after flushing, along with the size of its output files at that time,
+// so rows written after the checkpoint are truncated when resuming.
+type checkpoint struct {
+	Interval string             `json:"interval"`
+	Workers  []workerCheckpoint `json:"workers"`
+	Tenants  json.RawMessage    `json:"tenants"` // Static records, so the resumed run has the same topology
+}
+
+type workerCheckpoint struct {
+	State      generator.State  `json:"state"`
+	Files      map[string]int64 `json:"files,omitempty"` // Size of the output files, by path
+	TotalCalls int              `json:"totalCalls"`
+}
+
+func loadCheckpoint(file string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	if cp.Interval != interval.String() {
+		return nil, fmt.Errorf("checkpoint was saved with --interval=%s, resuming with --interval=%s", cp.Interval, interval)
+	}
+	return &cp, nil
+}
+
+// truncateFiles truncates the output files to their size at the checkpoint, removing the rows written after it.
+func (cp *checkpoint) truncateFiles() error {
+	for _, w := range cp.Workers {
+		for file, size := range w.Files {
+			if err := os.Truncate(file, size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkpointer saves the checkpoints of all workers to the file, at most once per interval,
+// or at every flush of QuestDB outputs.
+type checkpointer struct {
+	mu       sync.Mutex
+	file     string
+	interval time.Duration
+	savedAt  time.Time
+	cp       checkpoint
+}
+
+func newCheckpointer(file string, every time.Duration, tenants []*model.Tenant, workers int) (*checkpointer, error) {
+	b, err := json.Marshal(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointer{
+		file:     file,
+		interval: every,
+		cp: checkpoint{
+			Interval: interval.String(),
+			Workers:  make([]workerCheckpoint, workers),
+			Tenants:  b,
+		},
+	}, nil
+}
+
+// update updates the checkpoint of a worker right after it flushed, and saves the file if the interval has passed.
+// Rows flushed to QuestDB can't be removed when resuming, so the file is always saved after flushing to QuestDB.
+func (c *checkpointer) update(worker int, state generator.State, ss sinks, totalCalls int) error {
+	files := make(map[string]int64)
+	questdb := false
+	for _, o := range ss.outputs() {
+		if o.file == nil {
+			questdb = true
+			continue
+		}
+		fi, err := o.file.Stat()
+		if err != nil {
+			return err
+		}
+		files[o.file.Name()] = fi.Size()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cp.Workers[worker] = workerCheckpoint{State: state, Files: files, TotalCalls: totalCalls}
+	if !questdb && time.Since(c.savedAt) < c.interval {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file first, so the checkpoint file is never partially written.
+func (c *checkpointer) save() error {
+	b, err := json.Marshal(c.cp)
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		return err
+	}
+	c.savedAt = time.Now()
+	return nil
+}
+
+// close saves the latest checkpoints of all workers.
+func (c *checkpointer) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.save(); err != nil {
+		return writeError(err, "failed to save checkpoint")
+	}
+	log.Printf("Checkpoint saved to: %s", c.file)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// liveParams is the JSON of the generator.LiveParams in the control API.
+type liveParams struct {
+	Paused             bool               `json:"paused"`
+	MinLoad            float64            `json:"minLoad"`
+	MaxLoad            float64            `json:"maxLoad"`
+	OverrideRegionLoad bool               `json:"overrideRegionLoad"`
+	Interval           string             `json:"interval"`
+	SiteMultipliers    map[string]float64 `json:"siteMultipliers"`
+}
+
+// liveParamsUpdate is a partial update of the live parameters, omitted fields are unchanged.
+// Site multipliers are merged into the current ones, a null multiplier removes the site's multiplier.
+// Changing the load factors overrides the load profiles of the regions too, unless overrideRegionLoad is false.
+type liveParamsUpdate struct {
+	Paused             *bool               `json:"paused"`
+	MinLoad            *float64            `json:"minLoad"`
+	MaxLoad            *float64            `json:"maxLoad"`
+	OverrideRegionLoad *bool               `json:"overrideRegionLoad"`
+	Interval           *string             `json:"interval"`
+	SiteMultipliers    map[string]*float64 `json:"siteMultipliers"`
+}
+
+// serveControls serves the HTTP API changing the live parameters at the addr, in background:
+//   - GET /params returns the current parameters.
+//   - PATCH /params updates the parameters with the liveParamsUpdate of the body, and returns the updated parameters.
+//   - POST /pause and POST /resume pause and resume the generation.
+//
+// Changes are applied on the next tick of each worker. It fails if the addr can't be listened on.
+func serveControls(addr string, controls *generator.LiveControls, tenants []*model.Tenant) error {
+	sites := make(map[string]bool)
+	for _, site := range generator.Sites(tenants) {
+		sites[site.Id] = true
+	}
+	update := func(w http.ResponseWriter, update func(p *generator.LiveParams) error) {
+		p, err := controls.Update(update)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf(" > Live parameters updated: %s", formatLiveParams(p))
+		writeLiveParams(w, p)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/params", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeLiveParams(w, controls.Params())
+		case http.MethodPatch:
+			var req liveParamsUpdate
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			update(w, func(p *generator.LiveParams) error {
+				return req.apply(p, sites)
+			})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	for path, paused := range map[string]bool{"/pause": true, "/resume": false} {
+		paused := paused
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			update(w, func(p *generator.LiveParams) error {
+				p.Paused = paused
+				return nil
+			})
+		})
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return configError(err, "failed to listen for live controls at "+addr)
+	}
+	log.Printf("Serving live controls at: http://%s/params", addr)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf(" > Failed to serve live controls: %s", err)
+		}
+	}()
+	return nil
+}
+
+func (req liveParamsUpdate) apply(p *generator.LiveParams, sites map[string]bool) error {
+	if req.Paused != nil {
+		p.Paused = *req.Paused
+	}
+	if req.MinLoad != nil {
+		p.MinLoad = *req.MinLoad
+	}
+	if req.MaxLoad != nil {
+		p.MaxLoad = *req.MaxLoad
+	}
+	if req.MinLoad != nil || req.MaxLoad != nil {
+		p.OverrideRegionLoad = true
+	}
+	if req.OverrideRegionLoad != nil {
+		p.OverrideRegionLoad = *req.OverrideRegionLoad
+	}
+	if req.Interval != nil {
+		d, err := time.ParseDuration(*req.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+		p.Interval = d
+	}
+	for site, m := range req.SiteMultipliers {
+		if !sites[site] {
+			return fmt.Errorf("unknown site %s", site)
+		}
+		if m == nil {
+			delete(p.SiteMultipliers, site)
+			continue
+		}
+		p.SiteMultipliers[site] = *m
+	}
+	return nil
+}
+
+func writeLiveParams(w http.ResponseWriter, p generator.LiveParams) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(liveParams{
+		Paused:             p.Paused,
+		MinLoad:            p.MinLoad,
+		MaxLoad:            p.MaxLoad,
+		OverrideRegionLoad: p.OverrideRegionLoad,
+		Interval:           p.Interval.String(),
+		SiteMultipliers:    p.SiteMultipliers,
+	})
+}
+
+func formatLiveParams(p generator.LiveParams) string {
+	return fmt.Sprintf("paused=%t, minLoad=%g, maxLoad=%g, overrideRegionLoad=%t, interval=%s, siteMultipliers=%v",
+		p.Paused, p.MinLoad, p.MaxLoad, p.OverrideRegionLoad, p.Interval, p.SiteMultipliers)
+}
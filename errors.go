@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of the process, so scripts can tell user mistakes from QuestDB or disk failures.
+const (
+	exitFailure         = 1 // Unexpected error, or the shutdown timed out
+	exitConfigError     = 2 // Invalid arguments or input files
+	exitConnectionError = 3 // QuestDB can't be reached
+	exitWriteError      = 4 // Output files, checkpoints or reports can't be written
+)
+
+// exitError is an error with the exit code of its kind.
+type exitError struct {
+	code int
+	msg  string
+	err  error // Nil if the msg is the whole error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// configError returns an error of invalid arguments or input files, e.g. configError(err, "failed to parse interval").
+func configError(err error, msg string) error {
+	return &exitError{code: exitConfigError, msg: msg, err: err}
+}
+
+// configErrorf returns an error of invalid arguments, e.g. configErrorf("--workers must be positive").
+func configErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitConfigError, msg: fmt.Sprintf(format, args...)}
+}
+
+func connectionError(err error, msg string) error {
+	return &exitError{code: exitConnectionError, msg: msg, err: err}
+}
+
+// writeError returns an error of writing the outputs, keeping the exit code of the err if it has one
+// (e.g. QuestDB can't be reached anymore).
+func writeError(err error, msg string) error {
+	code := exitWriteError
+	var e *exitError
+	if errors.As(err, &e) {
+		code = e.code
+	}
+	return &exitError{code: code, msg: msg, err: err}
+}
+
+// exitCode returns the exit code of the err, the code of the outermost exitError wrapped by the err if any.
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
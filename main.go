@@ -4,24 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/bandplan"
+	"github.com/lnquy/quest-ei/pkg/generator"
 	"github.com/lnquy/quest-ei/pkg/model"
-	qdb "github.com/questdb/go-questdb-client"
 )
 
 var (
 	fStart                  string
 	fEnd                    string
 	fInterval               string
+	fNoOfTenants            int
+	fTenantsFile            string
+	fTenantRouting          string
+	fNoOfRegions            int
+	fRegionsFile            string
 	fNoOfSites              int
 	fNoOfChannelsPerSite    int
 	fNoOfFleetsPerSite      int
@@ -34,210 +40,639 @@ var (
 	fOutMetricsFile         string
 	fOutStaticFile          string
 	fInStaticFile           string
+	fInTopologyDir          string
+	fRepair                 bool
+	fTopologyMappingFile    string
 	fIsLive                 bool
-
-	start         time.Time
-	end           time.Time
-	interval      time.Duration
-	uniqueNameMap map[string]int
+	fBackfill               bool
+	fSpeed                  float64
+	fTargetRowsPerSec       float64
+	fTargetMBPerSec         float64
+	fRoamingRate            float64
+	fBand                   string
+	fBandPlanFile           string
+	fChannelSpacingKHz      float64
+	fDuplexOffsetMHz        float64
+	fEquipmentInterval      string
+	fOutagesPerDay          float64
+	fMessagesPerUnitHour    float64
+	fNoOfConsolesPerFleet   int
+	fConsoleCallsPerHour    float64
+	fPatchesPerConsoleDay   float64
+	fPoorSiteRate           float64
+	fQualityTiersFile       string
+	fMinChannelsPerSite     int
+	fMinFleetsPerSite       int
+	fMinTalkGroupsPerSite   int
+	fMinUnitsPerTalkGroup   int
+	fWorkers                int
+	fCheckpointFile         string
+	fCheckpointInterval     string
+	fResume                 bool
+	fSeed                   int64
+	fReportFile             string
+	fMetricsAddr            string
+	fControlAddr            string
+	fQuestdbAddr            string
+	fReconnectBufferMB      int
+	fSpillDir               string
+	fShutdownTimeout        string
+	fReconnectTimeout       string
+
+	start             time.Time
+	end               time.Time
+	interval          time.Duration
+	equipmentInterval time.Duration
+	checkpointEvery   time.Duration
+	shutdownTimeout   time.Duration
+	reconnectTimeout  time.Duration
+	bandPlan          bandplan.BandPlan
+	qualityTiers      []generator.QualityTier
 )
 
 func init() {
 	flag.StringVar(&fStart, "start", "2022-01-01T00:00:00Z", "Starting time to generate metrics data (RFC3339)")
 	flag.StringVar(&fEnd, "end", "2022-01-01T01:00:01Z", "Ending time to generate metrics data (RFC3339)")
 	flag.StringVar(&fInterval, "interval", "10s", "Interval duration for each loop when generating new metrics")
+	flag.IntVar(&fNoOfTenants, "tenants", 1, "Number of tenants, each tenant has its own regions and sites")
+	flag.StringVar(&fTenantsFile, "tenants-file", "", "Optional path to a JSON file of tenant configs (name and regions per tenant). If this is set, the --tenants option will be ignored")
+	flag.StringVar(&fTenantRouting, "tenant-routing", tenantRoutingNone, "How tenants are routed: none (same tables), table (tables suffixed by tenant), file (one --out-metrics-file per tenant)")
+	flag.IntVar(&fNoOfRegions, "regions", 1, "Number of regions, sites are evenly split into regions")
+	flag.StringVar(&fRegionsFile, "regions-file", "", "Optional path to a JSON file of region configs (topology sizes and load profile per region). If this is set, the --regions option will be ignored")
 	flag.IntVar(&fNoOfSites, "sites", 1, "Number of sites")
 	flag.IntVar(&fNoOfChannelsPerSite, "channels-per-site", 10, "Number of channels per site")
 	flag.IntVar(&fNoOfFleetsPerSite, "fleets-per-site", 5, "Number of fleets per site")
 	flag.IntVar(&fNoOfTalkGroupsPerSites, "talk-groups-per-site", 20, "Number of talk groups per site")
 	flag.IntVar(&fNoOfUnitsPerTalkGroup, "units-per-talk-group", 5, "Number of unit per talk group")
+	flag.IntVar(&fNoOfConsolesPerFleet, "consoles-per-fleet", 1, "Number of dispatch consoles per fleet")
+	flag.IntVar(&fMinChannelsPerSite, "min-channels-per-site", 1, "Minimum number of channels per site, sites are never pruned below this count")
+	flag.IntVar(&fMinFleetsPerSite, "min-fleets-per-site", 1, "Minimum number of fleets per site, sites are never pruned below this count")
+	flag.IntVar(&fMinTalkGroupsPerSite, "min-talk-groups-per-site", 1, "Minimum number of talk groups per site, sites are never pruned below this count")
+	flag.IntVar(&fMinUnitsPerTalkGroup, "min-units-per-talk-group", 1, "Minimum number of units per talk group, talk groups are never pruned below this count")
+	flag.Float64Var(&fPoorSiteRate, "poor-site-rate", 0.1, "Share of generated poor sites, which have less entities and degraded radio signal quality")
+	flag.StringVar(&fQualityTiersFile, "quality-tiers-file", "", "Optional path to a JSON file of site quality tiers (share and prune rates per tier). If this is set, the --poor-site-rate option will be ignored")
 	flag.IntVar(&fFlushBatchSize, "flush-batch-size", 10000, "Number of messages to flush to QuestDB in each batch. May need to increase flush-batch-buffer-mb if this value is too big.")
 	flag.IntVar(&fFlushBatchBufferMB, "flush-batch-buffer-mb", 100, "Number of MB memory will be used for buffering. Increase this value if flush-batch-size is too big")
 	flag.Float64Var(&fMinLoadFactor, "min-load", 0.0, `Minimum load factor of a site. At each "interval", at least "minLoadFactor" units will make a call`)
 	flag.Float64Var(&fMaxLoadFactor, "max-load", 1.0, `Maximum load factor of a site. At each "interval", at most "maxLoadFactor" units will make a call`)
+	flag.StringVar(&fQuestdbAddr, "questdb-addr", "127.0.0.1:9009", "Address of the QuestDB ILP TCP endpoint to flush to")
+	flag.IntVar(&fReconnectBufferMB, "reconnect-buffer-mb", 100, "With --live, MB of rows kept per connection while reconnecting to QuestDB, the oldest rows are dropped and reported as lost when it's full")
+	flag.StringVar(&fReconnectTimeout, "reconnect-timeout", "5m", "Without --live, maximum duration to reconnect to QuestDB after the connection broke, before exiting with a connection error")
+	flag.StringVar(&fSpillDir, "spill-dir", "", "With --live, optional directory to spill the rows to once the --reconnect-buffer-mb is full while reconnecting to QuestDB, instead of dropping them")
+	flag.StringVar(&fShutdownTimeout, "shutdown-timeout", "30s", "Maximum duration to flush and close all sinks after Ctrl+C or SIGTERM (e.g. while reconnecting to QuestDB), before exiting anyway")
 	flag.StringVar(&fOutMetricsFile, "out-metrics-file", "", "Optional path to write ILP messages to the file instead of flushing to QuestDB directly")
-	flag.StringVar(&fOutStaticFile, "out-static-file", "", "Optional path to write static data (sites, channels, fleets, talk groups, units) to JSON the file")
+	flag.StringVar(&fOutStaticFile, "out-static-file", "", "Optional path to write static data (tenants, regions, sites, channels, fleets, talk groups, units, consoles) to JSON the file")
 	flag.StringVar(&fInStaticFile, "in-static-file", "", "Optional path to provide static JSON file. If this is set, no static records will be generated and only call metrics will be generated")
+	flag.StringVar(&fInTopologyDir, "in-topology-dir", "", "Optional path to a directory of topology CSV files (sites.csv, channels.csv, fleets.csv, talk_groups.csv, units.csv and optional consoles.csv) to import static records from, instead of generating them")
+	flag.BoolVar(&fRepair, "repair", false, "Repair invalid static records (duplicated IDs, unknown references, sites without channels/fleets/talk groups/units) instead of failing")
+	flag.StringVar(&fTopologyMappingFile, "topology-mapping-file", "", "Optional path to a JSON file mapping the fields of each topology CSV file to its columns")
 	flag.BoolVar(&fIsLive, "live", false, "Generate the data in real time")
+	flag.Float64Var(&fTargetRowsPerSec, "target-rows-per-sec", 0, "Optional target rate of rows per second (all workers together) to pace the historical generation and flushing at, reporting the achieved rate and flush latencies. 0 to generate as fast as possible")
+	flag.Float64Var(&fTargetMBPerSec, "target-mb-per-sec", 0, "Optional target rate of MB of ILP messages per second, like --target-rows-per-sec. If both are set, the lower rate is held")
+	flag.Float64Var(&fSpeed, "speed", 1.0, "With --live, run the live clock this many times faster than the wall clock (e.g. 60 for 1 hour of data per minute). The live clock starts at --start if it's explicitly set, or now")
+	flag.BoolVar(&fBackfill, "backfill", false, "With --live, generate the historical data from --start up to now as fast as possible first, then continue in real time without gap. --end is ignored")
+	flag.StringVar(&fBand, "band", "uhf", "Band plan to allocate channel frequencies from (vhf, uhf, 700, 800)")
+	flag.StringVar(&fBandPlanFile, "band-plan-file", "", "Optional path to a JSON band plan file. If this is set, the --band option will be ignored")
+	flag.Float64Var(&fChannelSpacingKHz, "channel-spacing-khz", 0, "Optional channel spacing in kHz (e.g. 12.5, 25) to override the band plan's one")
+	flag.Float64Var(&fDuplexOffsetMHz, "duplex-offset-mhz", 0, "Optional duplex offset in MHz between channel's TX and RX frequencies to override the band plan's one")
+	flag.StringVar(&fEquipmentInterval, "equipment-interval", "5s", "Interval duration between site equipment readings. Set to 0 to disable site equipment readings")
+	flag.Float64Var(&fOutagesPerDay, "outages-per-day", 1.0, "Average number of outages per site per day (poor sites have 3 times more). Set to 0 to disable outages")
+	flag.Float64Var(&fMessagesPerUnitHour, "messages-per-unit-hour", 2.0, "Average number of short data/status messages sent by a unit per hour, each unit has its own rate in [0, 2*messages-per-unit-hour]. Set to 0 to disable messages")
+	flag.Float64Var(&fConsoleCallsPerHour, "console-calls-per-hour", 20.0, "Average number of calls made from a dispatch console per hour while a dispatcher is logged in")
+	flag.Float64Var(&fPatchesPerConsoleDay, "patches-per-console-day", 4.0, "Average number of talk group patches made from a dispatch console per day while a dispatcher is logged in")
+	flag.IntVar(&fWorkers, "workers", 1, "Number of workers generating the metrics in parallel, sites are partitioned into the workers and each worker writes to its own QuestDB connection or --out-metrics-file shard")
+	flag.StringVar(&fCheckpointFile, "checkpoint-file", "", "Optional path to save the progress of the historical generation to, so it can be continued with --resume after a crash")
+	flag.StringVar(&fCheckpointInterval, "checkpoint-interval", "30s", "Minimum duration between saving two checkpoints to the --checkpoint-file. Checkpoints are saved at every flush when flushing to QuestDB directly")
+	flag.BoolVar(&fResume, "resume", false, "Resume the historical generation from the --checkpoint-file, with the same arguments as the interrupted run")
+	flag.Int64Var(&fSeed, "seed", 0, "Optional seed of the random generators, the same seed and arguments generate the same data. 0 for a random seed")
+	flag.StringVar(&fReportFile, "report-file", "", "Optional path to write the JSON report of the run to (rows per table, bytes, flushes, flush latencies, throughput per second and errors)")
+	flag.StringVar(&fMetricsAddr, "metrics-addr", "", "Optional address (e.g. :9100) to serve the Prometheus metrics of the generation on, at /metrics")
+	flag.StringVar(&fControlAddr, "control-addr", "", "With --live, optional address (e.g. 127.0.0.1:9200) to serve the HTTP API changing the load factors, interval and per-site load multipliers, or pausing the generation at runtime")
+	flag.Float64Var(&fRoamingRate, "roaming-rate", 0.0, `Probability of a unit roaming to another site at each "interval". Set to 0 to disable roaming`)
+}
 
+// parseArgs parses and validates the arguments, invalid arguments are config errors.
+func parseArgs() error {
 	flag.Parse()
 
 	var err error
 	start, err = time.Parse(time.RFC3339, fStart)
-	panicIfError(err, "failed to parse start time from argument")
+	if err != nil {
+		return configError(err, "failed to parse start time from argument")
+	}
 	end, err = time.Parse(time.RFC3339, fEnd)
-	panicIfError(err, "failed to parse end time from argument")
+	if err != nil {
+		return configError(err, "failed to parse end time from argument")
+	}
 	interval, err = time.ParseDuration(fInterval)
-	panicIfError(err, "failed to parse interval from argument")
+	if err != nil {
+		return configError(err, "failed to parse interval from argument")
+	}
+	equipmentInterval, err = time.ParseDuration(fEquipmentInterval)
+	if err != nil {
+		return configError(err, "failed to parse equipment interval from argument")
+	}
+	checkpointEvery, err = time.ParseDuration(fCheckpointInterval)
+	if err != nil {
+		return configError(err, "failed to parse checkpoint interval from argument")
+	}
+	shutdownTimeout, err = time.ParseDuration(fShutdownTimeout)
+	if err != nil {
+		return configError(err, "failed to parse shutdown timeout from argument")
+	}
+	reconnectTimeout, err = time.ParseDuration(fReconnectTimeout)
+	if err != nil {
+		return configError(err, "failed to parse reconnect timeout from argument")
+	}
+
+	if fBandPlanFile != "" {
+		bandPlan, err = bandplan.Load(fBandPlanFile)
+	} else {
+		bandPlan, err = bandplan.Preset(fBand)
+	}
+	if err != nil {
+		return configError(err, "failed to load band plan")
+	}
+	flag.Visit(func(f *flag.Flag) { // Only override the band plan by explicitly set arguments
+		switch f.Name {
+		case "channel-spacing-khz":
+			bandPlan.ChannelSpacingKHz = fChannelSpacingKHz
+		case "duplex-offset-mhz":
+			bandPlan.DuplexOffsetMHz = fDuplexOffsetMHz
+		}
+	})
+	if err := bandPlan.Validate(); err != nil {
+		return configError(err, "invalid band plan")
+	}
 
-	uniqueNameMap = make(map[string]int, 5000)
+	qualityTiers, err = getQualityTiers()
+	if err != nil {
+		return configError(err, "failed to load quality tiers")
+	}
+
+	if fInStaticFile != "" && fInTopologyDir != "" {
+		return configErrorf("--in-static-file and --in-topology-dir can't be used together")
+	}
+
+	if fSpeed <= 0 {
+		return configErrorf("--speed must be positive")
+	}
+	if fTargetRowsPerSec < 0 || fTargetMBPerSec < 0 {
+		return configErrorf("target rates must not be negative")
+	}
+	if (fTargetRowsPerSec > 0 || fTargetMBPerSec > 0) && fIsLive {
+		return configErrorf("target rates are only supported in historical mode, live mode is paced by --interval and --speed")
+	}
+	if fBackfill && !fIsLive {
+		return configErrorf("--backfill requires --live")
+	}
+	if fControlAddr != "" && !fIsLive {
+		return configErrorf("--control-addr requires --live")
+	}
+	if fBackfill && !start.Before(time.Now()) {
+		return configErrorf("--start must be in the past to backfill")
+	}
+	if fCheckpointFile != "" && fIsLive {
+		return configErrorf("--checkpoint-file is only supported in historical mode")
+	}
+	if fResume && fCheckpointFile == "" {
+		return configErrorf("--resume requires --checkpoint-file")
+	}
+	if fWorkers < 1 {
+		return configErrorf("--workers must be positive")
+	}
+	if fSeed != 0 {
+		fake.Seed(fSeed) // Static records imported from CSV files get the same IDs
+	}
+
+	switch fTenantRouting {
+	case tenantRoutingNone, tenantRoutingTable:
+	case tenantRoutingFile:
+		if fOutMetricsFile == "" {
+			return configErrorf("--tenant-routing=file requires --out-metrics-file")
+		}
+	default:
+		return configErrorf("unknown tenant routing %q, supported: none, table, file", fTenantRouting)
+	}
+
+	return nil
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Printf("Error: %s", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run generates the static records and the metrics, its error decides the exit code of the process.
+func run() (err error) {
+	if err := parseArgs(); err != nil {
+		return err
+	}
 	defer func(t time.Time) {
 		log.Printf("Finished in %s", time.Since(t))
 	}(time.Now())
 
-	ctx := context.TODO()
-	sender := newQuestDbILPSender(ctx)
-	defer sender.Close()
-
-	sites := make([]*model.Site, 0, fNoOfSites)
+	// Cancelled on Ctrl+C or SIGTERM, so the workers and the QuestDB reconnects stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Closed once all sinks, checkpoints and the summary are closed, so the shutdown doesn't time out anymore
+	done := make(chan struct{})
+	defer close(done)
+	var tenants []*model.Tenant
+	cfg, err := newGeneratorConfig()
+	if err != nil {
+		return err
+	}
+	topology, err := generator.NewTopologyGenerator(cfg)
+	if err != nil {
+		return configError(err, "invalid generator config")
+	}
 
-	// Init static data (sites, channels, fleets, talk groups, units)
-	if fInStaticFile != "" { // Load from provided file
+	// Init static data (tenants, regions, sites, channels, fleets, talk groups, units)
+	var resumed *checkpoint
+	if fResume { // Load from the checkpoint of the interrupted run
+		log.Printf("Resuming from checkpoint file: %s", fCheckpointFile)
+		resumed, err = loadCheckpoint(fCheckpointFile)
+		if err != nil {
+			return configError(err, "failed to load checkpoint file")
+		}
+		tenants, err = loadStaticTenants(resumed.Tenants)
+		if err != nil {
+			return configError(err, "failed to decode static records of checkpoint")
+		}
+	} else if fInStaticFile != "" { // or load from provided file
 		log.Printf("Loading static records from JSON file: %s", fInStaticFile)
 		b, err := ioutil.ReadFile(fInStaticFile)
-		panicIfError(err, "failed to open static records JSON file")
-		panicIfError(json.Unmarshal(b, &sites), "failed to decode static records JSON file")
-		log.Printf("   + Sites: %d", len(sites))
-		log.Printf("   + Channels (%d*%dsites): ~%d", len(sites[0].Channels), len(sites), len(sites[0].Channels)*len(sites))
-		log.Printf("   + Fleets (%d*%dsites): ~%d", len(sites[0].Fleets), len(sites), len(sites[0].Fleets)*len(sites))
-		log.Printf("   + TalkGroups (%d*%dsites): ~%d", len(sites[0].TalkGroups), len(sites), len(sites[0].TalkGroups)*len(sites))
-		log.Printf("   + Units (%d*%dsites): ~%d", len(sites[0].Units), len(sites), len(sites[0].Units)*len(sites))
+		if err != nil {
+			return configError(err, "failed to open static records JSON file")
+		}
+		tenants, err = loadStaticTenants(b)
+		if err != nil {
+			return configError(err, "failed to decode static records JSON file")
+		}
+	} else if fInTopologyDir != "" { // or import from topology CSV files
+		log.Printf("Importing static records from topology CSV files: %s", fInTopologyDir)
+		tenants, err = loadTopology(topology)
+		if err != nil {
+			return configError(err, "failed to import topology CSV files")
+		}
 	} else { // or generate newly
 		log.Printf("Generating static records from provided arguments")
-		sites = generateStaticRecords(ctx, sender)
+		tenants, err = topology.Generate()
+		if err != nil {
+			return configError(err, "failed to generate static records")
+		}
+	}
+	// Validate the topology before generating, as every site needs channels, talk groups and units to make calls
+	if fRepair {
+		repaired, err := topology.Repair(tenants)
+		if err != nil {
+			return configError(err, "failed to repair static records")
+		}
+		log.Printf("Repaired %d static records", repaired)
+		if repaired > 0 && fInStaticFile != "" { // Static records loaded from file are not saved to QuestDB again
+			log.Printf("   Repaired records are missing from the static tables in QuestDB, use --out-static-file to keep them for later runs")
+		}
+	} else {
+		if err := generator.Validate(tenants); err != nil {
+			return configError(err, "invalid static records, use --repair to fix them")
+		}
+	}
+	logTopology(tenants)
+
+	// Each tenant is written to its own sink, which might share the same output with other tenants
+	if resumed != nil { // Rows written after the checkpoint are generated again
+		if err := resumed.truncateFiles(); err != nil {
+			return writeError(err, "failed to truncate output files to checkpoint")
+		}
+	}
+	ss, err := newSinks(ctx, tenants)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The run's error is kept over the report's one, as the report is written even if the run fails
+		if cerr := ingestion.close(fReportFile); err == nil {
+			err = cerr
+		}
+	}()
+	defer func() {
+		// Last flush of the outputs, its error is kept unless the run already failed
+		if cerr := ss.close(); err == nil {
+			err = cerr
+		}
+	}()
+	if fInStaticFile == "" && resumed == nil {
+		if err := saveStaticRecords(ctx, ss, tenants); err != nil {
+			return err
+		}
 	}
 	// Save static records to JSON file for later reuse, so we won't have to re-generate it again
 	if fOutStaticFile != "" {
-		b, err := json.Marshal(sites)
-		panicIfError(err, "failed to encode sites to JSON")
-		panicIfError(ioutil.WriteFile(fOutStaticFile, b, 0666), "failed to write static records JSON file")
+		b, err := json.Marshal(tenants)
+		if err != nil {
+			return fmt.Errorf("failed to encode tenants to JSON: %w", err)
+		}
+		if err := ioutil.WriteFile(fOutStaticFile, b, 0666); err != nil {
+			return writeError(err, "failed to write static records JSON file")
+		}
 		log.Printf("Static records JSON file written to: %s", fOutStaticFile)
 	}
 
+	if fMetricsAddr != "" {
+		if err := serveMetrics(fMetricsAddr, tenants); err != nil {
+			return err
+		}
+	}
+
+	if fControlAddr != "" { // Shared by all workers
+		cfg.Controls = generator.NewLiveControls(cfg)
+		if err := serveControls(fControlAddr, cfg.Controls, tenants); err != nil {
+			return err
+		}
+	}
+
+	// Sites are partitioned into the workers, each worker generates the metrics of its sites to its own sinks
+	calls, err := generator.NewCallGenerators(cfg, tenants)
+	if err != nil {
+		return configError(err, "failed to init call generators")
+	}
+	roamingUnits := 0
+	handlers := make([]*sinkHandler, 0, len(calls))
+	for i, g := range calls {
+		roamingUnits += g.RoamingUnits()
+		h := &sinkHandler{ctx: ctx, ss: ss, live: fIsLive, backfilling: fBackfill, worker: i, gen: g, metrics: fMetricsAddr != ""}
+		if len(calls) > 1 {
+			h.ss, err = newWorkerSinks(ctx, tenants, i)
+			if err != nil {
+				return err
+			}
+			defer func(ss sinks) {
+				if cerr := ss.close(); err == nil {
+					err = cerr
+				}
+			}(h.ss)
+			h.logPrefix = fmt.Sprintf("[worker#%d] ", i)
+		}
+		handlers = append(handlers, h)
+	}
+	if roamingUnits > 0 {
+		log.Printf("   + Roaming units: %d", roamingUnits)
+	}
+	if resumed != nil {
+		if len(resumed.Workers) != len(calls) {
+			return configErrorf("checkpoint was saved by %d workers, resuming with %d workers", len(resumed.Workers), len(calls))
+		}
+		for i, g := range calls {
+			if err := g.Restore(resumed.Workers[i].State); err != nil {
+				return configError(err, "failed to restore checkpoint")
+			}
+			handlers[i].totalCalls = resumed.Workers[i].TotalCalls
+			log.Printf("   + %sResuming from: %s", handlers[i].logPrefix, resumed.Workers[i].State.Next.Format(time.RFC3339))
+		}
+	}
+	if fCheckpointFile != "" {
+		checkpoints, err := newCheckpointer(fCheckpointFile, checkpointEvery, tenants, len(calls))
+		if err != nil {
+			return fmt.Errorf("failed to init checkpoints: %w", err)
+		}
+		for _, h := range handlers {
+			h.checkpoints = checkpoints
+			if err := h.checkpoint(); err != nil {
+				return writeError(err, "failed to save checkpoint")
+			}
+		}
+		defer func() {
+			if cerr := checkpoints.close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	// Stop generating on Ctrl+C or SIGTERM, then flush and close all sinks before the summary
+	go handleShutdown(cancel, done)
+
 	// Init dynamic data (call metrics)
-	sender = newQuestDbILPSender(ctx)
 	if !fIsLive {
-		log.Printf("Generating call metrics")
-		generateCallMetrics(ctx, sender, sites)
-		return
+		log.Printf("Generating call metrics with %d workers", len(calls))
+		if fTargetRowsPerSec > 0 || fTargetMBPerSec > 0 {
+			p := newPacer(fTargetRowsPerSec, fTargetMBPerSec, len(calls))
+			for _, h := range handlers {
+				h.pacer = p
+			}
+			defer p.close()
+			log.Printf(" > Pacing the ingestion at target rates, flushing every %d rows per worker", p.batch)
+		}
+		err := runWorkers(ctx, calls, func(ctx context.Context, i int) error {
+			h := handlers[i]
+			err := calls[i].Run(ctx, h)
+			if err != nil && err != context.Canceled {
+				return err
+			}
+			// Last flush (and checkpoint) including other metrics written since the last calls flush, also when interrupted
+			if ferr := h.flushAndCheckpoint(fmt.Sprintf("%d final call metrics", h.calls)); ferr != nil {
+				return ferr
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to generate call metrics: %w", err)
+		}
+		totalCalls := 0
+		for _, h := range handlers {
+			totalCalls += h.totalCalls
+		}
+		log.Printf("   + Total call metrics saved: %d", totalCalls)
+		if ctx.Err() != nil && fCheckpointFile != "" {
+			log.Printf("   + Generation interrupted, continue it with --resume")
+		}
+		return nil
 	}
 
 	// Generate live metrics
-	var ctxCancel context.CancelFunc
-	ctx, ctxCancel = signal.NotifyContext(ctx, os.Interrupt, os.Kill)
-	defer ctxCancel()
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	log.Printf("Generating realtime call metrics in live mode")
+	log.Printf("Generating realtime call metrics in live mode with %d workers", len(calls))
+	if fSpeed != 1 {
+		log.Printf(" > Live clock runs %gx faster than the wall clock", fSpeed)
+	}
+	if fBackfill {
+		log.Printf(" > Backfilling call metrics from %s up to now first", start.Format(time.RFC3339))
+	}
 	// Running in background until process is interrupted
-	go func() {
-		defer wg.Done()
-		generateLiveCallMetrics(ctx, sender, sites)
-	}()
-	wg.Wait()
+	err = runWorkers(ctx, calls, func(ctx context.Context, i int) error {
+		h := handlers[i]
+		run := calls[i].RunLive
+		if fBackfill {
+			run = calls[i].RunBackfillLive
+		}
+		if err := run(ctx, h); err != nil {
+			return err
+		}
+		// Rows written since the last tick flush, e.g. when interrupted while backfilling
+		return h.flush(fmt.Sprintf("%d final call metrics", h.calls))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate live call metrics: %w", err)
+	}
+	log.Printf(" > Stopped the live call metrics generation")
+	return nil
 }
 
-func newQuestDbILPSender(ctx context.Context) *qdb.LineSender {
-	s, err := qdb.NewLineSender(ctx,
-		qdb.WithBufferCapacity(fFlushBatchBufferMB*1024*1024),
-	)
-	panicIfError(err, "failed to init QuestDB line sender")
-	return s
+// handleShutdown cancels the generation on Ctrl+C or SIGTERM, so the workers stop and flush their sinks.
+// If the shutdown doesn't finish within the "shutdown-timeout" (e.g. QuestDB is down), the summary is printed
+// and the process exits, unless the run is done by then. Signals are no longer caught after the first one,
+// so a second Ctrl+C exits immediately.
+func handleShutdown(cancel context.CancelFunc, done <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+	log.Printf("Received %s, shutting down within %s (press Ctrl+C again to exit immediately)", sig, shutdownTimeout)
+	cancel()
+	select {
+	case <-done:
+		return
+	case <-time.After(shutdownTimeout):
+	}
+	log.Printf("Shutdown timed out after %s, exiting without flushing all sinks", shutdownTimeout)
+	if err := ingestion.close(fReportFile); err != nil {
+		log.Printf("Error: %s", err)
+	}
+	os.Exit(exitFailure)
 }
 
-func generateStaticRecords(ctx context.Context, s *qdb.LineSender) []*model.Site {
-	sites := make([]*model.Site, 0, fNoOfSites)
-
-	// Generate static records (sites, channels, fleets, talk groups, units)
-	for i := 0; i < fNoOfSites; i++ {
-		siteId := fake.UUID()
-		// Units of a site
-		units := make([]*model.Unit, 0, fNoOfUnitsPerTalkGroup*fNoOfTalkGroupsPerSites)
-		poorSite := false
-		if fake.Float64Range(0, 1.0) < 0.1 { // 10% sites
-			poorSite = true
-		}
-
-		// Fleets of a site
-		fleets := make([]*model.Fleet, 0, fNoOfFleetsPerSite)
-		poorFleetRate := fake.Float64Range(0, 0.1)
-		for j := 0; j < fNoOfFleetsPerSite; j++ {
-			if poorSite && fake.Float64Range(0.0, 1.0) < poorFleetRate { // poorSite has 0%-10% less fleets
-				continue
-			}
-			fleets = append(fleets, &model.Fleet{
-				Id:     fake.UUID(),
-				SiteId: siteId,
-				Name:   "Fleet#" + getUniqueName(fake.CountryAbr),
-				Status: model.StatusActive,
-			})
-		}
-
-		// Channels of a site
-		channels := make([]*model.Channel, 0, fNoOfChannelsPerSite)
-		poorChannelRate := fake.Float64Range(0, 0.1)
-		for j := 0; j < fNoOfChannelsPerSite; j++ {
-			if poorSite && fake.Float64Range(0.0, 1.0) < poorChannelRate { // poorSite has 0%-10% less channels
-				continue
-			}
-			channels = append(channels, &model.Channel{
-				Id:          fake.UUID(),
-				SiteId:      siteId,
-				Name:        "Channel#" + getUniqueName(fake.Noun),
-				TxFrequency: fake.Float64(),
-				RxFrequency: fake.Float64(),
-				Status:      model.StatusActive,
-			})
-		}
-
-		// TalkGroups of a site
-		talkGroups := make([]*model.TalkGroup, 0, fNoOfTalkGroupsPerSites)
-		poorTgRate := fake.Float64Range(0, 0.15)
-		for j := 0; j < fNoOfTalkGroupsPerSites; j++ {
-			if poorSite && fake.Float64Range(0.0, 1.0) < poorTgRate { // poorSite has 0%-15% less tgs
-				continue
-			}
-			talkGroup := model.TalkGroup{
-				Id:      fake.UUID(),
-				SiteId:  siteId,
-				FleetId: fleets[fake.IntRange(0, len(fleets)-1)].Id, // Randomly assign talk group to a fleet
-				Name:    "TalkGroup#" + getUniqueName(fake.LoremIpsumWord),
-				Status:  model.StatusActive,
+// runWorkers runs the call generators in parallel, one goroutine per generator, and waits for all of them.
+// The first error cancels the other generators and is returned.
+func runWorkers(ctx context.Context, calls []*generator.CallGenerator, run func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(calls))
+	wg := sync.WaitGroup{}
+	for i := range calls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if errs[i] = run(ctx, i); errs[i] != nil {
+				cancel()
 			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && err != context.Canceled {
+			return err
+		}
+	}
+	return nil
+}
 
-			// Units per talk group
-			poorUnitRate := fake.Float64Range(0, 0.2)
-			for k := 0; k < fNoOfUnitsPerTalkGroup; k++ {
-				if poorSite && fake.Float64Range(0.0, 1.0) < poorUnitRate { // poorSite has 0%-20% less units
-					continue
-				}
-				units = append(units, &model.Unit{
-					Id:          fake.UUID(),
-					SiteId:      siteId,
-					TalkGroupId: talkGroup.Id,
-					Name:        "Unit#" + getUniqueName(fake.Word),
-					Status:      model.StatusActive,
-				})
-			}
+// newGeneratorConfig returns the generator config from the arguments.
+func newGeneratorConfig() (generator.Config, error) {
+	tenants, err := getTenantConfigs()
+	if err != nil {
+		return generator.Config{}, configError(err, "failed to init tenants")
+	}
+	return generator.Config{
+		Tenants:              tenants,
+		ChannelsPerSite:      fNoOfChannelsPerSite,
+		FleetsPerSite:        fNoOfFleetsPerSite,
+		TalkGroupsPerSite:    fNoOfTalkGroupsPerSites,
+		UnitsPerTalkGroup:    fNoOfUnitsPerTalkGroup,
+		ConsolesPerFleet:     fNoOfConsolesPerFleet,
+		MinChannelsPerSite:   fMinChannelsPerSite,
+		MinFleetsPerSite:     fMinFleetsPerSite,
+		MinTalkGroupsPerSite: fMinTalkGroupsPerSite,
+		MinUnitsPerTalkGroup: fMinUnitsPerTalkGroup,
+		QualityTiers:         qualityTiers,
+		BandPlan:             bandPlan,
+		Start:                start,
+		End:                  end,
+		Interval:             interval,
+		EquipmentInterval:    equipmentInterval,
+		MinLoad:              fMinLoadFactor,
+		MaxLoad:              fMaxLoadFactor,
+		RoamingRate:          fRoamingRate,
+		OutagesPerDay:        fOutagesPerDay,
+		MessagesPerUnitHour:  fMessagesPerUnitHour,
+		ConsoleCallsPerHour:  fConsoleCallsPerHour,
+		PatchesPerConsoleDay: fPatchesPerConsoleDay,
+		Seed:                 fSeed,
+		Workers:              fWorkers,
+		Speed:                fSpeed,
+		LiveStart:            liveStart(),
+	}, nil
+}
 
-			talkGroups = append(talkGroups, &talkGroup)
+// liveStart returns the explicitly set start time to pin the live clock to, or zero to start the live clock now.
+func liveStart() time.Time {
+	var t time.Time
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "start" && !fBackfill {
+			t = start
 		}
+	})
+	return t
+}
 
-		// Site
-		sites = append(sites, &model.Site{
-			Id:         siteId,
-			Name:       "Site#" + getUniqueName(fake.Fruit),
-			Status:     model.StatusActive,
-			Channels:   channels,
-			Fleets:     fleets,
-			TalkGroups: talkGroups,
-			Units:      units,
-		})
+func logTopology(tenants []*model.Tenant) {
+	sites := generator.Sites(tenants)
+	channels, fleets, talkGroups, units, consoles := 0, 0, 0, 0, 0
+	for _, site := range sites {
+		channels += len(site.Channels)
+		fleets += len(site.Fleets)
+		talkGroups += len(site.TalkGroups)
+		units += len(site.Units)
+		consoles += len(site.Consoles)
 	}
+	log.Printf("   + Tenants: %d", len(tenants))
+	log.Printf("   + Sites: %d", len(sites))
+	log.Printf("   + Channels (~%d*%dsites): %d", channels/len(sites), len(sites), channels)
+	log.Printf("   + Fleets (~%d*%dsites): %d", fleets/len(sites), len(sites), fleets)
+	log.Printf("   + TalkGroups (~%d*%dsites): %d", talkGroups/len(sites), len(sites), talkGroups)
+	log.Printf("   + Units (~%d*%dsites): %d", units/len(sites), len(sites), units)
+	log.Printf("   + Consoles (~%d*%dsites): %d", consoles/len(sites), len(sites), consoles)
+}
 
-	// Flush static records to QuestDB or file
+// saveStaticRecords saves the static records of all tenants to their sinks.
+func saveStaticRecords(ctx context.Context, ss sinks, tenants []*model.Tenant) error {
 	ts := start.UnixNano()
-	for _, site := range sites {
+	for _, tenant := range tenants {
+		s := ss[tenant.Id]
+		log.Printf(" > Saving %q (%s) tenant with %d regions", tenant.Name, tenant.Id, len(tenant.Regions))
+		err := s.Table("tenants").
+			Symbol("name", tenant.Name).
+			Symbol("slug", tenant.Slug).
+			Int64Column("status", tenant.Status).
+			At(ctx, ts)
+		if err != nil {
+			return fmt.Errorf("failed to save tenants record: %w", err)
+		}
+
+		for _, region := range tenant.Regions {
+			log.Printf(" > Saving %q (%s) region with %d sites", region.Name, region.Id, len(region.Sites))
+			err := s.Table("regions").
+				Symbol("id", region.Id).
+				Symbol("name", region.Name).
+				Int64Column("status", region.Status).
+				At(ctx, ts)
+			if err != nil {
+				return fmt.Errorf("failed to save regions record: %w", err)
+			}
+		}
+	}
+	for _, site := range generator.Sites(tenants) {
+		s := ss[site.TenantId]
 		log.Printf(" > Saving %q (%s) site", site.Name, site.Id)
 		err := s.Table("sites").
 			Symbol("id", site.Id).
+			Symbol("region_id", site.RegionId).
 			Symbol("name", site.Name).
 			Int64Column("status", site.Status). // Active
 			At(ctx, ts)
-		panicIfError(err, "failed to save sites record")
+		if err != nil {
+			return fmt.Errorf("failed to save sites record: %w", err)
+		}
 
 		// err = s.Table("site_readings").
 		// 	Symbol("site_id", siteId).
@@ -257,7 +692,9 @@ func generateStaticRecords(ctx context.Context, s *qdb.LineSender) []*model.Site
 				Float64Column("rx_freq", channel.RxFrequency).
 				Int64Column("status", channel.Status).
 				At(ctx, ts)
-			panicIfError(err, "failed to save channels record")
+			if err != nil {
+				return fmt.Errorf("failed to save channels record: %w", err)
+			}
 		}
 
 		// Fleet
@@ -269,7 +706,9 @@ func generateStaticRecords(ctx context.Context, s *qdb.LineSender) []*model.Site
 				Symbol("name", fleet.Name).
 				Int64Column("status", fleet.Status).
 				At(ctx, ts)
-			panicIfError(err, "failed to save fleets record")
+			if err != nil {
+				return fmt.Errorf("failed to save fleets record: %w", err)
+			}
 		}
 
 		// TalkGroup
@@ -282,7 +721,9 @@ func generateStaticRecords(ctx context.Context, s *qdb.LineSender) []*model.Site
 				Symbol("name", talkGroup.Name).
 				Int64Column("status", talkGroup.Status).
 				At(ctx, ts)
-			panicIfError(err, "failed to save talk_groups record")
+			if err != nil {
+				return fmt.Errorf("failed to save talk_groups record: %w", err)
+			}
 		}
 
 		// Units
@@ -295,241 +736,30 @@ func generateStaticRecords(ctx context.Context, s *qdb.LineSender) []*model.Site
 				Symbol("name", units.Name).
 				Int64Column("status", units.Status).
 				At(ctx, ts)
-			panicIfError(err, "failed to save units record")
-		}
-
-		s = flushILPMessages(ctx, *s)
-		log.Printf("   Saved %q site", site.Name)
-	}
-
-	return sites
-}
-
-func generateCallMetrics(ctx context.Context, s *qdb.LineSender, sites []*model.Site) {
-	calls := make([]*model.Call, 0, fFlushBatchSize)
-	totalCalls := 0
-	for start.Before(end) {
-		for _, site := range sites {
-			var unit *model.Unit
-			// For each "interval", only "loadFactor" units will make a call
-			// This randomization simulates different load on each system at a time
-			loadFactor := fake.Float64Range(fMinLoadFactor, fMaxLoadFactor)
-			startSec := start.Hour()*3600 + start.Minute()*60 + start.Second()
-			if startSec >= (14*3600+0*60+0) && startSec <= (24*3600+0*60+0) {
-				// During low load duration [14:00, 24:00], the loadFactor is lower than normal
-				loadFactor *= fake.Float64Range(0, 0.5)
-			}
-			unitCalls := int(float64(len(site.Units)) * loadFactor)
-			isLowLoadSite := fake.Float64Range(0, 1.0) < 0.3 // 30% chance to be a low load site
-			lowLoadSkipRate := fake.Float64Range(0, 0.5)     // Chance to drop a call on low load site
-			for j := 0; j < unitCalls; j++ {
-				if isLowLoadSite && fake.Float64Range(0, 1.0) < lowLoadSkipRate {
-					continue // Randomly skip 0-50% of calls
-				}
-
-				unit = site.Units[fake.IntRange(0, len(site.Units)-1)]                 // Randomly pick a unit
-				talkGroup := site.TalkGroups[fake.IntRange(0, len(site.TalkGroups)-1)] // Randomly pick a talkGroup
-				endedAt := fake.DateRange(start, start.Add(15*time.Minute))
-				calls = append(calls, &model.Call{
-					Id:                     fake.UUID(),
-					SiteId:                 site.Id,
-					ChannelId:              site.Channels[fake.IntRange(0, len(site.Channels)-1)].Id, // Randomly pick a channel
-					FleetId:                talkGroup.FleetId,
-					SourceUnitId:           unit.Id,
-					DestinationTalkGroupId: talkGroup.Id,
-					StartedAt:              start,
-					EndedAt:                endedAt, // Randomize call duration, at most 15m
-					DurationSecond:         int64(endedAt.Sub(start).Seconds()),
-				})
+			if err != nil {
+				return fmt.Errorf("failed to save units record: %w", err)
 			}
-
-			if len(calls) <= fFlushBatchSize {
-				continue
-			}
-
-			log.Printf(" > Flushing %d call metrics: start=%s, end=%s", len(calls), start.Format(time.RFC3339), end.Format(time.RFC3339))
-			for _, c := range calls {
-				err := s.Table("calls").
-					Symbol("site_id", c.SiteId).
-					Symbol("channel_id", c.ChannelId).
-					Symbol("fleet_id", c.FleetId).
-					Symbol("source_unit_id", c.SourceUnitId).
-					Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
-					StringColumn("id", c.Id).
-					TimestampColumn("started_at", c.StartedAt.UnixNano()).
-					TimestampColumn("ended_at", c.EndedAt.UnixNano()).
-					Int64Column("duration_sec", c.DurationSecond).
-					At(ctx, c.StartedAt.UnixNano())
-				panicIfError(err, "failed to save calls record")
-			}
-			s = flushILPMessages(ctx, *s)
-			totalCalls += len(calls)
-			log.Printf("   + %d call metrics saved, totalSaved=%d", len(calls), totalCalls)
-			calls = make([]*model.Call, 0, fFlushBatchSize) // Reset batch
 		}
 
-		start = start.Add(interval) // Jump to the next interval
-	}
-
-	if len(calls) == 0 {
-		return
-	}
-	// Last flush
-	log.Printf(" > Flushing %d final call metrics", len(calls))
-	for _, c := range calls {
-		err := s.Table("calls").
-			Symbol("site_id", c.SiteId).
-			Symbol("channel_id", c.ChannelId).
-			Symbol("fleet_id", c.FleetId).
-			Symbol("source_unit_id", c.SourceUnitId).
-			Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
-			StringColumn("id", c.Id).
-			TimestampColumn("started_at", c.StartedAt.UnixNano()).
-			TimestampColumn("ended_at", c.EndedAt.UnixNano()).
-			Int64Column("duration_sec", c.DurationSecond).
-			At(ctx, c.StartedAt.UnixNano())
-		panicIfError(err, "failed to save calls record")
-	}
-	s = flushILPMessages(ctx, *s)
-	totalCalls += len(calls)
-	log.Printf("   + %d final call metrics saved, totalSaved=%d", len(calls), totalCalls)
-}
-
-func generateLiveCallMetrics(ctx context.Context, s *qdb.LineSender, sites []*model.Site) {
-	totalCalls := 0
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	ingestMetricFunc := func(now time.Time) {
-		calls := make([]*model.Call, 0, fFlushBatchSize)
-		// Generating
-		for _, site := range sites {
-			var unit *model.Unit
-			// For each "interval", only "loadFactor" units will make a call
-			loadFactor := fake.Float64Range(fMinLoadFactor, fMaxLoadFactor)
-			unitCalls := int(float64(len(site.Units)) * loadFactor)
-			isLowLoadSite := fake.Float64Range(0, 1.0) < 0.3 // 30% chance to be a low load site
-			lowLoadSkipRate := fake.Float64Range(0, 0.5)     // Chance to drop a call on low load site
-			for j := 0; j < unitCalls; j++ {
-				if isLowLoadSite && fake.Float64Range(0, 1.0) < lowLoadSkipRate {
-					continue // Randomly skip 0-50% of calls
-				}
-
-				unit = site.Units[fake.IntRange(0, len(site.Units)-1)]                 // Randomly pick a unit
-				talkGroup := site.TalkGroups[fake.IntRange(0, len(site.TalkGroups)-1)] // Randomly pick a talkGroup
-				endedAt := fake.DateRange(now, now.Add(5*time.Minute))
-				calls = append(calls, &model.Call{
-					Id:                     fake.UUID(),
-					SiteId:                 site.Id,
-					ChannelId:              site.Channels[fake.IntRange(0, len(site.Channels)-1)].Id, // Randomly pick a channel
-					FleetId:                talkGroup.FleetId,
-					SourceUnitId:           unit.Id,
-					DestinationTalkGroupId: talkGroup.Id,
-					StartedAt:              now,
-					EndedAt:                endedAt, // Randomize call duration, at most 5m
-					DurationSecond:         int64(endedAt.Sub(now).Seconds()),
-				})
-			}
-
-			if len(calls) <= fFlushBatchSize {
-				continue
-			}
-
-			log.Printf(" > Flushing %d call metrics at: %s", len(calls), now.Format(time.RFC3339))
-			for _, c := range calls {
-				err := s.Table("calls").
-					Symbol("site_id", c.SiteId).
-					Symbol("channel_id", c.ChannelId).
-					Symbol("fleet_id", c.FleetId).
-					Symbol("source_unit_id", c.SourceUnitId).
-					Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
-					StringColumn("id", c.Id).
-					TimestampColumn("started_at", c.StartedAt.UnixNano()).
-					TimestampColumn("ended_at", c.EndedAt.UnixNano()).
-					Int64Column("duration_sec", c.DurationSecond).
-					At(ctx, c.StartedAt.UnixNano())
-				panicIfError(err, "failed to save calls record")
+		// Consoles
+		log.Printf("   + Saving %d consoles", len(site.Consoles))
+		for _, console := range site.Consoles {
+			err := s.Table("consoles").
+				Symbol("id", console.Id).
+				Symbol("site_id", console.SiteId).
+				Symbol("fleet_id", console.FleetId).
+				Symbol("name", console.Name).
+				Int64Column("status", console.Status).
+				At(ctx, ts)
+			if err != nil {
+				return fmt.Errorf("failed to save consoles record: %w", err)
 			}
-			s = flushILPMessages(ctx, *s)
-			totalCalls += len(calls)
-			log.Printf("   + %d call metrics saved, totalSaved=%d", len(calls), totalCalls)
-			calls = make([]*model.Call, 0, fFlushBatchSize) // Reset batch
-		}
-
-		// Ingest
-		if len(calls) == 0 {
-			return
-		}
-		// Last flush
-		log.Printf(" > Flushing %d final call metrics at: %s", len(calls), now.Format(time.RFC3339))
-		for _, c := range calls {
-			err := s.Table("calls").
-				Symbol("site_id", c.SiteId).
-				Symbol("channel_id", c.ChannelId).
-				Symbol("fleet_id", c.FleetId).
-				Symbol("source_unit_id", c.SourceUnitId).
-				Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
-				StringColumn("id", c.Id).
-				TimestampColumn("started_at", c.StartedAt.UnixNano()).
-				TimestampColumn("ended_at", c.EndedAt.UnixNano()).
-				Int64Column("duration_sec", c.DurationSecond).
-				At(ctx, c.StartedAt.UnixNano())
-			panicIfError(err, "failed to save calls record")
-		}
-		s = flushILPMessages(ctx, *s)
-		totalCalls += len(calls)
-		log.Printf("   + %d final call metrics saved, totalSaved=%d", len(calls), totalCalls)
-	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf(" > context canceled, stopping the background live call metrics generation")
-			return
-		case now := <-ticker.C:
-			ingestMetricFunc(now)
 		}
-	}
-}
-
-func flushILPMessages(ctx context.Context, s qdb.LineSender) *qdb.LineSender {
-	if fOutMetricsFile == "" {
-		panicIfError(s.Flush(ctx), "failed to flush ILP messages to QuestDB")
-		return &s
-	}
-
-	// Write ILP to file instead of flushing to QuestDB directly.
-	// This file then can be used on `tsbs_load_questdb --file qdb-data.ilp --workers 4`
-	f, err := os.OpenFile(fOutMetricsFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
-	panicIfError(err, "failed to open file to write")
-	defer f.Close()
-	_, err = f.WriteString(s.Messages())
-	panicIfError(err, "failed to write ILP messages to file")
-	_ = s.Close() // Close to remove all buffered messages first
-	return newQuestDbILPSender(ctx)
-}
 
-func getUniqueName(nameFunc func() string) string {
-	name := nameFunc()
-	if name == "" {
-		return fake.UUID()
-	}
-	if len(name) == 1 {
-		return name + strconv.Itoa(int(fake.Int64()))
-	}
-	name = strings.ReplaceAll(name, " ", "_")
-	name = strings.ToUpper(string(name[0])) + name[1:]
-	count, ok := uniqueNameMap[name]
-	if ok {
-		uniqueNameMap[name] = 1
-		return name
-	}
-	uniqueNameMap[name] = count + 1
-	return name + strconv.Itoa(count+1)
-}
-
-func panicIfError(err error, msg string) {
-	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+		if err := flushILPMessages(ctx, s.output); err != nil {
+			return err
+		}
+		log.Printf("   Saved %q site", site.Name)
 	}
+	return nil
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// serveMetrics serves the Prometheus metrics of the generation at /metrics of the addr, in background. It fails if the addr can't be listened on.
+// The metrics are written in the Prometheus text format, so no client library is needed.
+func serveMetrics(addr string, tenants []*model.Tenant) error {
+	sites := make(map[string]*model.Site)
+	for _, site := range generator.Sites(tenants) {
+		sites[site.Id] = site
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		ingestion.writeMetrics(bw, sites)
+		_ = bw.Flush()
+	})
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return configError(err, "failed to listen for metrics at "+addr)
+	}
+	log.Printf("Serving metrics at: http://%s/metrics", addr)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf(" > Failed to serve metrics: %s", err)
+		}
+	}()
+	return nil
+}
+
+// writeMetrics writes the current statistics in the Prometheus text format.
+func (s *ingestStats) writeMetrics(w io.Writer, sites map[string]*model.Site) {
+	s.mu.Lock()
+	generated := make(map[string]int64)
+	var buffered, bufferCap, backlog, spilled int64
+	for _, o := range s.outputs {
+		for table, r := range o.rows {
+			generated[table] += atomic.LoadInt64(&r.generated)
+		}
+		buffered += atomic.LoadInt64(&o.buffered)
+		bufferCap += int64(fFlushBatchBufferMB) * 1024 * 1024
+		if o.conn != nil {
+			backlog += atomic.LoadInt64(&o.conn.backlogBytes)
+			spilled += atomic.LoadInt64(&o.conn.spillBytes)
+		}
+	}
+	flushed := make(map[string]int64, len(s.rows))
+	for table, n := range s.rows {
+		flushed[table] = int64(n)
+	}
+	loadFactors := make(map[string]float64, len(s.loadFactors))
+	for site, f := range s.loadFactors {
+		loadFactors[site] = f
+	}
+	bytes, flushes, flushErrors, reconnects, lostRows := s.bytes, len(s.latencies), s.flushErrors, s.reconnects, s.lostRows
+	s.mu.Unlock()
+
+	writeTableCounter(w, "quest_ei_generated_rows_total", "Rows generated, by table.", generated)
+	writeTableCounter(w, "quest_ei_flushed_rows_total", "Rows flushed to QuestDB or files, by table.", flushed)
+	writeMetric(w, "quest_ei_flushed_bytes_total", "counter", "Bytes of ILP messages flushed to QuestDB or files.", bytes)
+	writeMetric(w, "quest_ei_flushes_total", "counter", "Flushes of ILP messages.", flushes)
+	writeMetric(w, "quest_ei_flush_errors_total", "counter", "Flushes of ILP messages which failed, including broken QuestDB connections.", flushErrors)
+	writeMetric(w, "quest_ei_sender_reconnects_total", "counter", "Reconnects of the QuestDB connections.", reconnects)
+	writeMetric(w, "quest_ei_lost_rows_total", "counter", "Rows dropped while disconnected from QuestDB, as the backlog was full.", lostRows)
+	writeMetric(w, "quest_ei_buffer_used_bytes", "gauge", "Bytes of the rows buffered by the outputs, not flushed yet.", buffered)
+	writeMetric(w, "quest_ei_buffer_capacity_bytes", "gauge", "Buffer capacity of the outputs, see --flush-batch-buffer-mb.", bufferCap)
+	writeMetric(w, "quest_ei_reconnect_backlog_bytes", "gauge", "Bytes of the rows kept in memory while disconnected from QuestDB, see --reconnect-buffer-mb.", backlog)
+	writeMetric(w, "quest_ei_reconnect_spilled_bytes", "gauge", "Bytes of the rows spilled to --spill-dir while disconnected from QuestDB, not sent yet.", spilled)
+
+	ids := make([]string, 0, len(loadFactors))
+	for id := range loadFactors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Fprintf(w, "# HELP quest_ei_site_load_factor Load factor of the site at its last step, 0 while the site is down.\n")
+	fmt.Fprintf(w, "# TYPE quest_ei_site_load_factor gauge\n")
+	for _, id := range ids {
+		site := sites[id]
+		fmt.Fprintf(w, "quest_ei_site_load_factor{tenant_id=\"%s\",site_id=\"%s\",site_name=\"%s\"} %g\n",
+			escapeLabel(site.TenantId), escapeLabel(id), escapeLabel(site.Name), loadFactors[id])
+	}
+}
+
+func writeMetric(w io.Writer, name, typ, help string, val interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, val)
+}
+
+func writeTableCounter(w io.Writer, name, help string, rows map[string]int64) {
+	tables := make([]string, 0, len(rows))
+	for table := range rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, table := range tables {
+		fmt.Fprintf(w, "%s{table=\"%s\"} %d\n", name, escapeLabel(table), rows[table])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(val string) string {
+	return labelEscaper.Replace(val)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pacer holds the ingestion at the "target-rows-per-sec" and/or "target-mb-per-sec" rates.
+// Handlers flush every batch rows, then wait until the rows flushed by all workers so far are due by the target rates.
+// The achieved rates and flush latencies are reported every 10 seconds, and for the whole run at the end.
+type pacer struct {
+	mu              sync.Mutex
+	targetRows      float64 // Rows per second, 0 for no target
+	targetBytes     float64 // Bytes per second, 0 for no target
+	batch           int     // Rows per flush of each worker, so each worker flushes ~10 times per second
+	start           time.Time
+	rows            int
+	bytes           int
+	latencies       []time.Duration
+	reportedAt      time.Time
+	reportedRows    int
+	reportedBytes   int
+	reportedFlushes int
+}
+
+const bytesPerRowEstimate = 256 // To size the batches of a bytes only target
+
+func newPacer(rowsPerSec, mbPerSec float64, workers int) *pacer {
+	rows := rowsPerSec
+	if rows == 0 || (mbPerSec > 0 && mbPerSec*1024*1024/bytesPerRowEstimate < rows) {
+		rows = mbPerSec * 1024 * 1024 / bytesPerRowEstimate
+	}
+	now := time.Now()
+	return &pacer{
+		targetRows:  rowsPerSec,
+		targetBytes: mbPerSec * 1024 * 1024,
+		batch:       int(math.Max(rows/10/float64(workers), 1)),
+		start:       now,
+		reportedAt:  now,
+	}
+}
+
+// wait records a flush of rows and bytes, then blocks until the target rates allow more rows to be flushed.
+func (p *pacer) wait(rows, bytes int, latency time.Duration) {
+	p.mu.Lock()
+	p.rows += rows
+	p.bytes += bytes
+	p.latencies = append(p.latencies, latency)
+	due := time.Duration(0)
+	if p.targetRows > 0 {
+		due = time.Duration(float64(p.rows) / p.targetRows * float64(time.Second))
+	}
+	if p.targetBytes > 0 {
+		if d := time.Duration(float64(p.bytes) / p.targetBytes * float64(time.Second)); d > due {
+			due = d
+		}
+	}
+	if time.Since(p.reportedAt) >= 10*time.Second {
+		p.report(p.reportedAt, p.rows-p.reportedRows, p.bytes-p.reportedBytes, p.latencies[p.reportedFlushes:])
+		p.reportedAt, p.reportedRows, p.reportedBytes, p.reportedFlushes = time.Now(), p.rows, p.bytes, len(p.latencies)
+	}
+	p.mu.Unlock()
+	time.Sleep(time.Until(p.start.Add(due)))
+}
+
+// close reports the achieved rates and flush latencies of the whole run.
+func (p *pacer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	log.Printf("Ingestion rate of the run:")
+	p.report(p.start, p.rows, p.bytes, p.latencies)
+}
+
+func (p *pacer) report(since time.Time, rows, bytes int, latencies []time.Duration) {
+	elapsed := time.Since(since).Seconds()
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	log.Printf("   + Rows/sec: %.0f (target: %s), MB/sec: %.2f (target: %s), rows=%d, flushes=%d",
+		float64(rows)/elapsed, formatTarget(p.targetRows, 1), float64(bytes)/elapsed/1024/1024, formatTarget(p.targetBytes, 1024*1024),
+		rows, len(latencies))
+	log.Printf("   + Flush latency: p50=%s, p90=%s, p99=%s, max=%s",
+		percentile(sorted, 0.5), percentile(sorted, 0.9), percentile(sorted, 0.99), percentile(sorted, 1))
+}
+
+func formatTarget(target, unit float64) string {
+	if target == 0 {
+		return "none"
+	}
+	return strconv.FormatFloat(target/unit, 'g', -1, 64)
+}
+
+// percentile returns the p percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
@@ -0,0 +1,148 @@
+package bandplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+)
+
+// BandPlan describes the frequency range channels of a site can be allocated from.
+// Frequencies are the base station transmit (downlink) frequencies, the receive (uplink)
+// frequency of a channel is its transmit frequency shifted by the duplex offset.
+type BandPlan struct {
+	Name              string  `json:"name"`
+	StartMHz          float64 `json:"startMHz"`
+	EndMHz            float64 `json:"endMHz"`
+	ChannelSpacingKHz float64 `json:"channelSpacingKHz"`
+	DuplexOffsetMHz   float64 `json:"duplexOffsetMHz"`
+}
+
+// Presets are the commonly used band plans for land mobile radio systems.
+var Presets = map[string]BandPlan{
+	"vhf": {Name: "vhf", StartMHz: 150.05, EndMHz: 174, ChannelSpacingKHz: 12.5, DuplexOffsetMHz: -4.6},
+	"uhf": {Name: "uhf", StartMHz: 450, EndMHz: 470, ChannelSpacingKHz: 12.5, DuplexOffsetMHz: 5},
+	"700": {Name: "700", StartMHz: 769, EndMHz: 775, ChannelSpacingKHz: 12.5, DuplexOffsetMHz: 30},
+	"800": {Name: "800", StartMHz: 851, EndMHz: 869, ChannelSpacingKHz: 25, DuplexOffsetMHz: -45},
+}
+
+// Preset returns the preset band plan by its name (vhf, uhf, 700, 800).
+func Preset(name string) (BandPlan, error) {
+	p, ok := Presets[strings.ToLower(name)]
+	if !ok {
+		return BandPlan{}, fmt.Errorf("unknown band %q, supported bands: vhf, uhf, 700, 800", name)
+	}
+	return p, nil
+}
+
+// Load reads the band plan from a JSON file.
+func Load(path string) (BandPlan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return BandPlan{}, fmt.Errorf("failed to read band plan file: %w", err)
+	}
+	var p BandPlan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return BandPlan{}, fmt.Errorf("failed to decode band plan file: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return BandPlan{}, err
+	}
+	return p, nil
+}
+
+// Validate checks whether the band plan can allocate at least one channel.
+func (p BandPlan) Validate() error {
+	if p.spacingHz() <= 0 {
+		return fmt.Errorf("invalid band plan %q: channel spacing must be at least 1 Hz", p.Name)
+	}
+	if p.EndMHz <= p.StartMHz {
+		return fmt.Errorf("invalid band plan %q: end frequency must be greater than start frequency", p.Name)
+	}
+	return nil
+}
+
+// Channels returns the number of channels available in the band plan.
+// The range is divided in whole Hz, so the last channel isn't lost to floating point errors.
+func (p BandPlan) Channels() int {
+	rangeHz := int64(math.Round((p.EndMHz - p.StartMHz) * 1e6))
+	return int(rangeHz/p.spacingHz()) + 1
+}
+
+// channel returns the index of the channel of the txMHz frequency, false if it isn't a channel of the band plan.
+func (p BandPlan) channel(txMHz float64) (int, bool) {
+	offsetHz := int64(math.Round((txMHz - p.StartMHz) * 1e6))
+	if offsetHz < 0 || offsetHz%p.spacingHz() != 0 {
+		return 0, false
+	}
+	idx := int(offsetHz / p.spacingHz())
+	return idx, idx < p.Channels()
+}
+
+func (p BandPlan) spacingHz() int64 {
+	return int64(math.Round(p.ChannelSpacingKHz * 1000))
+}
+
+// Frequency returns the transmit and receive frequencies (MHz) of the channel at idx.
+func (p BandPlan) Frequency(idx int) (txMHz, rxMHz float64) {
+	txMHz = roundKHz(p.StartMHz + float64(idx)*p.ChannelSpacingKHz/1000)
+	rxMHz = roundKHz(txMHz + p.DuplexOffsetMHz)
+	return txMHz, rxMHz
+}
+
+// Allocator randomly allocates channels from a band plan without duplicates.
+type Allocator struct {
+	plan      BandPlan
+	f         *fake.Faker
+	allocated map[int]struct{}
+}
+
+// NewAllocator returns an allocator for a single site, so frequencies
+// allocated by the same allocator are unique. Channels are picked with f,
+// so a seeded Faker allocates the same frequencies.
+func (p BandPlan) NewAllocator(f *fake.Faker) *Allocator {
+	return &Allocator{
+		plan:      p,
+		f:         f,
+		allocated: make(map[int]struct{}),
+	}
+}
+
+// Reserve marks the channel of the txMHz frequency as allocated, e.g. a frequency imported with the topology,
+// so it isn't allocated again. Frequencies out of the band plan are ignored.
+func (a *Allocator) Reserve(txMHz float64) {
+	if idx, ok := a.plan.channel(txMHz); ok {
+		a.allocated[idx] = struct{}{}
+	}
+}
+
+// Allocate returns the transmit and receive frequencies (MHz) of a free channel.
+func (a *Allocator) Allocate() (txMHz, rxMHz float64, err error) {
+	free := a.plan.Channels() - len(a.allocated)
+	if free <= 0 {
+		return 0, 0, fmt.Errorf("no free channel left in band plan %q (%d channels)", a.plan.Name, a.plan.Channels())
+	}
+
+	// Pick the n-th free channel, so allocation never has to retry
+	n := a.f.IntRange(0, free-1)
+	for idx := 0; idx < a.plan.Channels(); idx++ {
+		if _, ok := a.allocated[idx]; ok {
+			continue
+		}
+		if n == 0 {
+			a.allocated[idx] = struct{}{}
+			txMHz, rxMHz = a.plan.Frequency(idx)
+			return txMHz, rxMHz, nil
+		}
+		n--
+	}
+	return 0, 0, fmt.Errorf("no free channel left in band plan %q", a.plan.Name) // Unreachable
+}
+
+// roundKHz rounds the MHz frequency to 0.1 kHz to get rid of floating point errors.
+func roundKHz(mhz float64) float64 {
+	return math.Round(mhz*10000) / 10000
+}
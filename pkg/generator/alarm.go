@@ -0,0 +1,76 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+type alarmDefinition struct {
+	code       string
+	severity   string
+	sourceType string
+}
+
+var (
+	// degradingAlarms are raised when the sensors of the cause component start drifting away
+	degradingAlarms = map[outageCause]alarmDefinition{
+		outageOverheat: {code: "PA_TEMPERATURE_HIGH", severity: model.AlarmSeverityWarning, sourceType: "pa"},
+		outageAntenna:  {code: "VSWR_HIGH", severity: model.AlarmSeverityWarning, sourceType: "antenna"},
+		outagePower:    {code: "MAINS_POWER_FAILURE", severity: model.AlarmSeverityMajor, sourceType: "power_supply"},
+		outageBackhaul: {code: "BACKHAUL_LATENCY_HIGH", severity: model.AlarmSeverityWarning, sourceType: "backhaul"},
+	}
+	// outageAlarms are raised when the cause component fails, along with siteDownAlarm
+	outageAlarms = map[outageCause]alarmDefinition{
+		outageOverheat: {code: "PA_SHUTDOWN", severity: model.AlarmSeverityCritical, sourceType: "pa"},
+		outageAntenna:  {code: "TX_SHUTDOWN", severity: model.AlarmSeverityCritical, sourceType: "antenna"},
+		outagePower:    {code: "BATTERY_EXHAUSTED", severity: model.AlarmSeverityCritical, sourceType: "power_supply"},
+		outageBackhaul: {code: "BACKHAUL_LINK_DOWN", severity: model.AlarmSeverityCritical, sourceType: "backhaul"},
+	}
+	siteDownAlarm = alarmDefinition{code: "SITE_DOWN", severity: model.AlarmSeverityCritical, sourceType: "site"}
+)
+
+// updateAlarms raises or clears the alarms of the site equipment after its state has changed at the ts time.
+// All alarms of an outage are cleared at once when the site is back to normal.
+func (e *siteEquipment) updateAlarms(ts time.Time) []*model.Alarm {
+	switch e.state {
+	case equipmentDegrading:
+		return e.raiseAlarms(ts, degradingAlarms[e.cause])
+	case equipmentOutage:
+		if e.cause == outageForced { // Replaces the alarms of the failing component, if any
+			return append(e.clearAlarms(ts), e.raiseAlarms(ts, siteDownAlarm)...)
+		}
+		return e.raiseAlarms(ts, outageAlarms[e.cause], siteDownAlarm)
+	}
+	return e.clearAlarms(ts)
+}
+
+func (e *siteEquipment) clearAlarms(ts time.Time) []*model.Alarm {
+	cleared := e.alarms
+	for _, a := range cleared {
+		a.ClearedAt = ts
+	}
+	e.alarms = nil
+	return cleared
+}
+
+func (e *siteEquipment) raiseAlarms(ts time.Time, definitions ...alarmDefinition) []*model.Alarm {
+	raised := make([]*model.Alarm, 0, len(definitions))
+	for _, d := range definitions {
+		sourceId := e.siteId
+		if d.sourceType != siteDownAlarm.sourceType {
+			sourceId += "/" + d.sourceType
+		}
+		raised = append(raised, &model.Alarm{
+			Id:         e.f.UUID(),
+			SiteId:     e.siteId,
+			Code:       d.code,
+			Severity:   d.severity,
+			SourceType: d.sourceType,
+			SourceId:   sourceId,
+			RaisedAt:   ts,
+		})
+	}
+	e.alarms = append(e.alarms, raised...)
+	return raised
+}
@@ -0,0 +1,248 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// CallGenerator generates the metrics of a topology, step by step.
+// It keeps the state of the simulation (unit registrations, site equipments and consoles) between steps,
+// so a CallGenerator must not be used concurrently.
+type CallGenerator struct {
+	cfg                Config
+	src                *source
+	f                  *fake.Faker // Draws from src
+	next               time.Time   // Start of the next historical step
+	sites              []*model.Site
+	siteMap            map[string]*model.Site
+	tenantSites        map[string][]*model.Site // Units only roam between sites of the same tenant
+	equipments         map[string]*siteEquipment
+	consoles           map[string][]*consoleActivity // Initialized on the first step
+	calls              []model.Call                  // Unit calls of a site, reused by every step
+	loadFactors        map[string]float64            // Load factor of each site at the last step, by site ID
+	multipliers        map[string]float64            // Load factor multipliers of the live controls, by site ID
+	overrideRegionLoad bool                          // Whether the load factor range of the live controls applies to all sites
+	roamingUnits       int
+}
+
+// NewCallGenerator returns a CallGenerator of all sites of the tenants, which must pass Validate.
+// Units are registered to their current sites, so calls are originated from where the units are.
+func NewCallGenerator(cfg Config, tenants []*model.Tenant) (*CallGenerator, error) {
+	cfg.Workers = 1
+	generators, err := NewCallGenerators(cfg, tenants)
+	if err != nil {
+		return nil, err
+	}
+	return generators[0], nil
+}
+
+// NewCallGenerators partitions the sites of the tenants into Config.Workers CallGenerators (at most one per site),
+// so the metrics can be generated in parallel, e.g. with a goroutine and a Handler per CallGenerator.
+// Sites are assigned to the CallGenerators in a round-robin way and units only roam between the sites
+// of their own CallGenerator.
+// Each CallGenerator has its own random source derived from Config.Seed, so a seeded run generates the same metrics
+// for the same number of workers, regardless of how the goroutines are scheduled.
+func NewCallGenerators(cfg Config, tenants []*model.Tenant) ([]*CallGenerator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := Validate(tenants); err != nil {
+		return nil, err
+	}
+
+	sites := Sites(tenants)
+	workers := cfg.Workers
+	if workers > len(sites) {
+		workers = len(sites)
+	}
+	shards := make([][]*model.Site, workers)
+	for i, site := range sites {
+		shards[i%workers] = append(shards[i%workers], site)
+	}
+
+	generators := make([]*CallGenerator, 0, workers)
+	for i, shard := range shards {
+		seed := cfg.Seed
+		if seed != 0 {
+			seed += int64(i) + 1 // Don't share the random sequence of the TopologyGenerator
+		}
+		generators = append(generators, newCallGenerator(cfg, newSource(seed), shard))
+	}
+	return generators, nil
+}
+
+func newCallGenerator(cfg Config, src *source, sites []*model.Site) *CallGenerator {
+	f := fake.NewCustom(src)
+	g := &CallGenerator{
+		cfg:          cfg,
+		src:          src,
+		f:            f,
+		next:         cfg.Start,
+		sites:        sites,
+		siteMap:      make(map[string]*model.Site, len(sites)),
+		tenantSites:  make(map[string][]*model.Site),
+		loadFactors:  make(map[string]float64, len(sites)),
+		equipments:   newSiteEquipments(f, sites, cfg.OutagesPerDay),
+		roamingUnits: initUnitRegistrations(sites),
+	}
+	for _, site := range sites {
+		g.siteMap[site.Id] = site
+		g.tenantSites[site.TenantId] = append(g.tenantSites[site.TenantId], site)
+		for _, unit := range site.Units {
+			if unit.MessagesPerHour == 0 { // Units loaded from static file without message rate
+				unit.MessagesPerHour = newUnitMessageRate(f, cfg.MessagesPerUnitHour)
+			}
+		}
+	}
+	return g
+}
+
+// Sites returns the sites the CallGenerator generates the metrics of.
+func (g *CallGenerator) Sites() []*model.Site {
+	return g.sites
+}
+
+// LoadFactors returns a copy of the load factor of each site at the last step (0 while the site is down), by site ID.
+// It must be called between steps (e.g. in Handler.HandleStep).
+func (g *CallGenerator) LoadFactors() map[string]float64 {
+	loadFactors := make(map[string]float64, len(g.loadFactors))
+	for site, f := range g.loadFactors {
+		loadFactors[site] = f
+	}
+	return loadFactors
+}
+
+// RoamingUnits returns the number of units registered to another site than their home site
+// when the CallGenerator was created.
+func (g *CallGenerator) RoamingUnits() int {
+	return g.roamingUnits
+}
+
+// Run generates the historical metrics between Config.Start (or the restored State) and Config.End,
+// one Config.Interval step at a time.
+// Calls last at most 15 minutes, and sites have lower load during the [14:00, 24:00] local time of their region.
+func (g *CallGenerator) Run(ctx context.Context, h Handler) error {
+	for ts := g.next; ts.Before(g.cfg.End); ts = g.next {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := g.Step(ts, ts.Add(g.cfg.Interval), h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step generates the historical metrics of the [from, to) time range.
+func (g *CallGenerator) Step(from, to time.Time, h Handler) error {
+	return g.step(h, from, to, from, 15*time.Minute, true)
+}
+
+// step generates all rows of the [from, to) time range, then calls the HandleStep of the handler.
+// Unit calls and channel readings are timestamped at the ts time, and unit calls last at most maxCallDuration.
+func (g *CallGenerator) step(h Handler, from, to, ts time.Time, maxCallDuration time.Duration, lowLoadHours bool) error {
+	g.resetRand()
+	if g.consoles == nil {
+		g.consoles = newConsoleActivities(g.f, g.sites, from)
+	}
+	if err := g.roamUnits(h, ts); err != nil {
+		return err
+	}
+
+	for _, site := range g.sites {
+		equipment := g.equipments[site.Id]
+		multiplier, multiplied := g.multipliers[site.Id]
+		equipment.forced = multiplied && multiplier == 0 // Taken down by the live controls
+		if err := emitEquipmentReadings(h, equipment, from, to, g.cfg.EquipmentInterval); err != nil {
+			return err
+		}
+		readings := newChannelReadings(g.f, site, ts) // RF conditions of the site's channels in this step
+		// For each step, only "loadFactor" units will make a call
+		// This randomization simulates different load on each system at a time
+		loadFactor := g.f.Float64Range(g.siteLoadFactorRange(site))
+		if lowLoadHours {
+			localTs := ts
+			if site.Region != nil {
+				localTs = ts.Add(time.Duration(site.Region.TimezoneOffsetHours) * time.Hour)
+			}
+			tsSec := localTs.Hour()*3600 + localTs.Minute()*60 + localTs.Second()
+			if tsSec >= (14*3600+0*60+0) && tsSec <= (24*3600+0*60+0) {
+				// During low load duration [14:00, 24:00], the loadFactor is lower than normal
+				loadFactor *= g.f.Float64Range(0, 0.5)
+			}
+		}
+		if multiplied {
+			loadFactor *= multiplier
+		}
+		if equipment.down() {
+			loadFactor = 0 // No call or message can be made while the site is out of service
+		} else if err := emitUnitMessages(g.f, h, site, from, to, g.cfg.MessagesPerUnitHour); err != nil {
+			return err
+		}
+		g.loadFactors[site.Id] = loadFactor
+		unitCalls := int(float64(len(site.RegisteredUnits)) * loadFactor)
+		isLowLoadSite := g.f.Float64Range(0, 1.0) < 0.3 // 30% chance to be a low load site
+		lowLoadSkipRate := g.f.Float64Range(0, 0.5)     // Chance to drop a call on low load site
+		g.calls = g.calls[:0]
+		for j := 0; j < unitCalls; j++ {
+			if isLowLoadSite && g.f.Float64Range(0, 1.0) < lowLoadSkipRate {
+				continue // Randomly skip 0-50% of calls
+			}
+
+			unit := site.RegisteredUnits[g.f.IntRange(0, len(site.RegisteredUnits)-1)] // Randomly pick a unit registered to the site
+			talkGroup := site.TalkGroups[g.f.IntRange(0, len(site.TalkGroups)-1)]      // Randomly pick a talkGroup
+			channelIdx := g.f.IntRange(0, len(site.Channels)-1)                        // Randomly pick a channel
+			endedAt := g.f.DateRange(ts, ts.Add(maxCallDuration))
+			g.calls = append(g.calls, model.Call{
+				Id:                     g.f.UUID(),
+				TenantId:               site.TenantId,
+				RegionId:               site.RegionId,
+				SiteId:                 site.Id,
+				ChannelId:              site.Channels[channelIdx].Id,
+				FleetId:                talkGroup.FleetId,
+				SourceUnitId:           unit.Id,
+				SourceUnitHomeSiteId:   unit.SiteId,
+				DestinationTalkGroupId: talkGroup.Id,
+				StartedAt:              ts,
+				EndedAt:                endedAt, // Randomize call duration
+				DurationSecond:         int64(endedAt.Sub(ts).Seconds()),
+			})
+			applyCallSignalQuality(g.f, &g.calls[len(g.calls)-1], site, readings[channelIdx])
+		}
+		consoleCalls, err := g.generateConsoleActivity(h, site, readings, equipment.down(), from, to)
+		if err != nil {
+			return err
+		}
+		for i := range g.calls {
+			if err := h.HandleRow(Row{TenantId: site.TenantId, Value: &g.calls[i]}); err != nil {
+				return err
+			}
+		}
+		for _, c := range consoleCalls {
+			if err := h.HandleRow(Row{TenantId: site.TenantId, Value: c}); err != nil {
+				return err
+			}
+		}
+		if err := emitChannelReadings(h, site, readings); err != nil {
+			return err
+		}
+	}
+	g.next = to
+	if err := h.HandleStep(from, to); err != nil {
+		return fmt.Errorf("failed to handle step [%s, %s): %w", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// siteLoadFactorRange returns the [min, max] load factor of a site from its region's load profile,
+// unless the load factor range is overridden by the live controls.
+func (g *CallGenerator) siteLoadFactorRange(site *model.Site) (float64, float64) {
+	if site.Region == nil || site.Region.MaxLoad == 0 || g.overrideRegionLoad {
+		return g.cfg.MinLoad, g.cfg.MaxLoad
+	}
+	return site.Region.MinLoad, site.Region.MaxLoad
+}
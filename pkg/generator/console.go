@@ -0,0 +1,161 @@
+package generator
+
+import (
+	"time"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// consoleActivity tracks the dispatcher shifts and active talk group patches of a console.
+type consoleActivity struct {
+	console    *model.Console
+	talkGroups []*model.TalkGroup // Talk groups of the console's fleet
+	session    *model.ConsoleSession
+	nextLogin  time.Time // When the next dispatcher logs in, while no one is logged in
+	sessionEnd time.Time
+	patches    []*model.TalkGroupPatch
+	patchEnds  []time.Time
+}
+
+// newConsoleActivities returns the console activities of all consoles, grouped by site ID.
+// All consoles start without any dispatcher logged in.
+func newConsoleActivities(f *fake.Faker, sites []*model.Site, ts time.Time) map[string][]*consoleActivity {
+	activities := make(map[string][]*consoleActivity, len(sites))
+	for _, site := range sites {
+		for _, console := range site.Consoles {
+			talkGroups := make([]*model.TalkGroup, 0)
+			for _, tg := range site.TalkGroups {
+				if tg.FleetId == console.FleetId {
+					talkGroups = append(talkGroups, tg)
+				}
+			}
+			if len(talkGroups) == 0 { // Fleet without talk group, dispatchers serve the whole site instead
+				talkGroups = site.TalkGroups
+			}
+			activities[site.Id] = append(activities[site.Id], &consoleActivity{
+				console:    console,
+				talkGroups: talkGroups,
+				nextLogin:  ts.Add(time.Duration(f.IntRange(0, 30)) * time.Minute),
+			})
+		}
+	}
+	return activities
+}
+
+// generateConsoleActivity simulates the consoles of a site in the [from, to) time range:
+//   - Dispatchers log in for a 4-10h shift, then the console is left unattended for up to 1h.
+//   - Logged in dispatchers make calls to the talk groups of their fleet and patch 2 talk groups together.
+//
+// Console sessions and talk group patches are emitted directly, while console originated calls are returned
+// to be emitted along with unit calls.
+func (g *CallGenerator) generateConsoleActivity(h Handler, site *model.Site, readings []*model.ChannelReading,
+	down bool, from, to time.Time) ([]*model.Call, error) {
+	calls := make([]*model.Call, 0)
+	hours := to.Sub(from).Hours()
+	for _, a := range g.consoles[site.Id] {
+		// Shift changes
+		for {
+			if a.session == nil && a.nextLogin.Before(to) {
+				a.session = &model.ConsoleSession{
+					Id:        g.f.UUID(),
+					ConsoleId: a.console.Id,
+					SiteId:    site.Id,
+					FleetId:   a.console.FleetId,
+					Operator:  g.f.Username(),
+					LoginAt:   a.nextLogin,
+				}
+				a.sessionEnd = a.nextLogin.Add(time.Duration(g.f.IntRange(4*60, 10*60)) * time.Minute)
+				if err := emitConsoleSession(h, site, a.session); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if a.session != nil && a.sessionEnd.Before(to) {
+				a.session.LogoutAt = a.sessionEnd
+				if err := emitConsoleSession(h, site, a.session); err != nil {
+					return nil, err
+				}
+				a.session = nil
+				a.nextLogin = a.sessionEnd.Add(time.Duration(g.f.IntRange(0, 60)) * time.Minute)
+				continue
+			}
+			break
+		}
+
+		// Ended patches
+		patches, patchEnds := a.patches[:0], a.patchEnds[:0]
+		for i, p := range a.patches {
+			if a.patchEnds[i].Before(to) {
+				p.UnpatchedAt = a.patchEnds[i]
+				if err := emitTalkGroupPatch(h, site, p); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			patches, patchEnds = append(patches, p), append(patchEnds, a.patchEnds[i])
+		}
+		a.patches, a.patchEnds = patches, patchEnds
+
+		if a.session == nil || down || len(site.Channels) == 0 || len(a.talkGroups) == 0 {
+			continue // Nobody at the console, or the site is out of service
+		}
+
+		// New patches
+		if len(a.talkGroups) > 1 && g.f.Float64Range(0, 1.0) < g.cfg.PatchesPerConsoleDay*hours/24 {
+			tgIdx := g.f.IntRange(0, len(a.talkGroups)-1)
+			patchedTgIdx := (tgIdx + g.f.IntRange(1, len(a.talkGroups)-1)) % len(a.talkGroups)
+			p := &model.TalkGroupPatch{
+				Id:                 g.f.UUID(),
+				SiteId:             site.Id,
+				ConsoleId:          a.console.Id,
+				TalkGroupId:        a.talkGroups[tgIdx].Id,
+				PatchedTalkGroupId: a.talkGroups[patchedTgIdx].Id,
+				PatchedAt:          g.f.DateRange(from, to),
+			}
+			if err := emitTalkGroupPatch(h, site, p); err != nil {
+				return nil, err
+			}
+			a.patches = append(a.patches, p)
+			a.patchEnds = append(a.patchEnds, p.PatchedAt.Add(time.Duration(g.f.IntRange(5, 120))*time.Minute))
+		}
+
+		// Console originated calls
+		for i := poisson(g.f, g.cfg.ConsoleCallsPerHour*hours); i > 0; i-- {
+			talkGroup := a.talkGroups[g.f.IntRange(0, len(a.talkGroups)-1)]
+			channelIdx := g.f.IntRange(0, len(site.Channels)-1)
+			startedAt := g.f.DateRange(from, to)
+			endedAt := g.f.DateRange(startedAt, startedAt.Add(2*time.Minute)) // Dispatchers keep it short, at most 2m
+			call := &model.Call{
+				Id:                     g.f.UUID(),
+				TenantId:               site.TenantId,
+				RegionId:               site.RegionId,
+				SiteId:                 site.Id,
+				ChannelId:              site.Channels[channelIdx].Id,
+				FleetId:                talkGroup.FleetId,
+				SourceConsoleId:        a.console.Id,
+				DestinationTalkGroupId: talkGroup.Id,
+				StartedAt:              startedAt,
+				EndedAt:                endedAt,
+				DurationSecond:         int64(endedAt.Sub(startedAt).Seconds()),
+			}
+			applyCallSignalQuality(g.f, call, site, readings[channelIdx])
+			calls = append(calls, call)
+		}
+	}
+	return calls, nil
+}
+
+// emitConsoleSession emits the current state of the session, a login record for an active session,
+// or a logout record for an ended session.
+func emitConsoleSession(h Handler, site *model.Site, cs *model.ConsoleSession) error {
+	session := *cs // Sessions are updated on logout, so handlers get a copy of the current state
+	return h.HandleRow(Row{TenantId: site.TenantId, Value: &session})
+}
+
+// emitTalkGroupPatch emits the current state of the patch, a patch record for an active patch,
+// or an unpatch record for a removed patch.
+func emitTalkGroupPatch(h Handler, site *model.Site, p *model.TalkGroupPatch) error {
+	patch := *p // Patches are updated on unpatch, so handlers get a copy of the current state
+	return h.HandleRow(Row{TenantId: site.TenantId, Value: &patch})
+}
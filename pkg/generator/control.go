@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LiveParams are the parameters of a live generation which can be changed while it runs.
+type LiveParams struct {
+	Paused             bool    // No metrics are generated while paused, the generation continues from now when resumed
+	MinLoad            float64 // Load factor range of sites without load profile from their region, like Config.MinLoad
+	MaxLoad            float64
+	OverrideRegionLoad bool               // Whether MinLoad and MaxLoad apply to the sites with a load profile too
+	Interval           time.Duration      // Duration between two ticks of the live clock
+	SiteMultipliers    map[string]float64 // Multiplier of the load factor by site ID, e.g. 5 for an incident, or 0 to take the site down
+}
+
+// LiveControls holds the LiveParams shared by the CallGenerators of a live generation (see Config.Controls).
+// Changes are applied by each CallGenerator on its next tick.
+type LiveControls struct {
+	mu     sync.Mutex
+	params LiveParams
+}
+
+// NewLiveControls returns the LiveControls initialized with the parameters of the cfg.
+func NewLiveControls(cfg Config) *LiveControls {
+	return &LiveControls{params: LiveParams{
+		MinLoad:         cfg.MinLoad,
+		MaxLoad:         cfg.MaxLoad,
+		Interval:        cfg.Interval,
+		SiteMultipliers: make(map[string]float64),
+	}}
+}
+
+// Params returns a copy of the current parameters.
+func (c *LiveControls) Params() LiveParams {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.params.clone()
+}
+
+// Update changes the parameters with the update func, the changes are discarded if the func returns an error
+// or the changed parameters are invalid. Unchanged parameters are not validated again.
+func (c *LiveControls) Update(update func(p *LiveParams) error) (LiveParams, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.params.clone()
+	if err := update(&p); err != nil {
+		return c.params.clone(), err
+	}
+	if err := p.validate(c.params); err != nil {
+		return c.params.clone(), err
+	}
+	c.params = p
+	return p.clone(), nil
+}
+
+func (p LiveParams) clone() LiveParams {
+	multipliers := make(map[string]float64, len(p.SiteMultipliers))
+	for site, m := range p.SiteMultipliers {
+		multipliers[site] = m
+	}
+	p.SiteMultipliers = multipliers
+	return p
+}
+
+// validate validates the parameters changed from the old ones, with the same rules as Config.validate.
+func (p LiveParams) validate(old LiveParams) error {
+	if (p.MinLoad != old.MinLoad || p.MaxLoad != old.MaxLoad) && (p.MinLoad < 0 || p.MinLoad > p.MaxLoad) {
+		return fmt.Errorf("invalid load factors: min=%f, max=%f", p.MinLoad, p.MaxLoad)
+	}
+	if p.Interval != old.Interval && p.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	for site, m := range p.SiteMultipliers {
+		if oldM, ok := old.SiteMultipliers[site]; (!ok || m != oldM) && m < 0 {
+			return fmt.Errorf("multiplier of site %s must not be negative", site)
+		}
+	}
+	return nil
+}
+
+// applyControls applies the current LiveParams of Config.Controls to the generator,
+// and returns whether the generation is paused.
+func (g *CallGenerator) applyControls() (paused bool) {
+	if g.cfg.Controls == nil {
+		return false
+	}
+	p := g.cfg.Controls.Params()
+	g.cfg.MinLoad, g.cfg.MaxLoad, g.cfg.Interval = p.MinLoad, p.MaxLoad, p.Interval
+	g.overrideRegionLoad = p.OverrideRegionLoad
+	g.multipliers = p.SiteMultipliers
+	return p.Paused
+}
@@ -0,0 +1,242 @@
+package generator
+
+import (
+	"math"
+	"time"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+type equipmentState = string
+
+const (
+	equipmentNormal    equipmentState = "normal"
+	equipmentDegrading equipmentState = "degrading" // Sensors are drifting, the site will go down at the end of this state
+	equipmentOutage    equipmentState = "outage"    // Site is out of service, no call can be made
+)
+
+type outageCause = string
+
+const (
+	outageOverheat outageCause = "overheat" // PA temperature keeps rising, then the PA shuts down
+	outageAntenna  outageCause = "antenna"  // VSWR keeps rising (damaged feeder/antenna), then the transmitter shuts down
+	outagePower    outageCause = "power"    // Mains power is lost, then the battery runs out
+	outageBackhaul outageCause = "backhaul" // Backhaul latency keeps rising, then the link is lost
+	outageForced   outageCause = "forced"   // Site is taken down by the live controls, until it's brought back up
+)
+
+var outageCauses = []outageCause{outageOverheat, outageAntenna, outagePower, outageBackhaul}
+
+// siteEquipment simulates the base station hardware of a site.
+// Sites go through normal -> degrading -> outage -> normal states,
+// the sensors of the cause component drift away while degrading, before the site goes down.
+type siteEquipment struct {
+	f             *fake.Faker
+	siteId        string
+	tenantId      string
+	poor          bool
+	outagesPerDay float64
+	state         equipmentState
+	cause         outageCause
+	stateSince    time.Time
+	stateUntil    time.Time
+	battery       float64 // Battery percent when entering the current state
+	alarms        []*model.Alarm
+	nextReading   time.Time // Carried across steps, so readings are "equipment-interval" apart for any step interval
+	forced        bool      // Whether the site must be down, set by the live controls before each step
+
+	// Healthy values of the sensors
+	temperatureC  float64
+	forwardPowerW float64
+	vswr          float64
+	latencyMs     float64
+}
+
+func newSiteEquipments(f *fake.Faker, sites []*model.Site, outagesPerDay float64) map[string]*siteEquipment {
+	equipments := make(map[string]*siteEquipment, len(sites))
+	for _, site := range sites {
+		equipments[site.Id] = &siteEquipment{
+			f:             f,
+			siteId:        site.Id,
+			tenantId:      site.TenantId,
+			poor:          site.Poor,
+			outagesPerDay: outagesPerDay,
+			state:         equipmentNormal,
+			battery:       100,
+			temperatureC:  f.Float64Range(38, 48),
+			forwardPowerW: f.Float64Range(40, 50),
+			vswr:          f.Float64Range(1.1, 1.3),
+			latencyMs:     f.Float64Range(5, 20),
+		}
+		if site.Poor { // Poor sites run hotter with worse antenna systems and backhaul
+			equipments[site.Id].temperatureC += 8
+			equipments[site.Id].vswr += 0.3
+			equipments[site.Id].latencyMs *= 3
+		}
+	}
+	return equipments
+}
+
+// down reports whether the site is out of service.
+func (e *siteEquipment) down() bool {
+	return e.state == equipmentOutage
+}
+
+// advance moves the state machine to the ts time, step is the elapsed time since the last advance.
+// It reports whether the state has changed.
+// A forced site goes down at once, and is back to normal as soon as it's not forced anymore.
+func (e *siteEquipment) advance(ts time.Time, step time.Duration) bool {
+	forcedDown := e.state == equipmentOutage && e.cause == outageForced
+	if e.forced || forcedDown {
+		if e.forced == forcedDown {
+			return false
+		}
+		e.battery = e.batteryAt(ts)
+		if e.forced {
+			e.cause = outageForced
+			e.setState(equipmentOutage, ts, 0)
+		} else {
+			e.setState(equipmentNormal, ts, 0)
+		}
+		return true
+	}
+	switch e.state {
+	case equipmentNormal:
+		outagesPerDay := e.outagesPerDay
+		if e.poor {
+			outagesPerDay *= 3 // Poor sites go down more often
+		}
+		if e.f.Float64Range(0, 1.0) >= outagesPerDay*step.Hours()/24 {
+			return false
+		}
+		e.battery = e.batteryAt(ts)
+		e.cause = outageCauses[e.f.IntRange(0, len(outageCauses)-1)]
+		e.setState(equipmentDegrading, ts, e.f.IntRange(5, 30)) // Degrading for 5-30m before going down
+	case equipmentDegrading:
+		if ts.Before(e.stateUntil) {
+			return false
+		}
+		e.battery = e.batteryAt(ts)
+		e.setState(equipmentOutage, ts, e.f.IntRange(2, 60)) // Down for 2-60m
+	case equipmentOutage:
+		if ts.Before(e.stateUntil) {
+			return false
+		}
+		e.battery = e.batteryAt(ts)
+		e.setState(equipmentNormal, ts, 0)
+	}
+	return true
+}
+
+func (e *siteEquipment) setState(state equipmentState, ts time.Time, minutes int) {
+	e.state = state
+	e.stateSince = ts
+	e.stateUntil = ts.Add(time.Duration(minutes) * time.Minute)
+}
+
+// progress returns how far [0, 1] the current state has gone at the ts time.
+func (e *siteEquipment) progress(ts time.Time) float64 {
+	total := e.stateUntil.Sub(e.stateSince)
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(float64(ts.Sub(e.stateSince))/float64(total), 1)
+}
+
+// batteryAt returns the battery percent at the ts time.
+// Battery drains out while the mains power is lost, and is recharged at 0.5%/minute otherwise.
+func (e *siteEquipment) batteryAt(ts time.Time) float64 {
+	if e.cause == outagePower {
+		switch e.state {
+		case equipmentDegrading:
+			return e.battery * (1 - e.progress(ts))
+		case equipmentOutage:
+			return 0
+		}
+	}
+	return math.Min(e.battery+ts.Sub(e.stateSince).Minutes()*0.5, 100)
+}
+
+func (e *siteEquipment) reading(ts time.Time) *model.SiteEquipmentReading {
+	r := &model.SiteEquipmentReading{
+		SiteId:            e.siteId,
+		State:             e.state,
+		PaTemperatureC:    e.temperatureC + e.f.Float64Range(-1, 1),
+		ForwardPowerW:     e.forwardPowerW * e.f.Float64Range(0.98, 1.02),
+		Vswr:              e.vswr + e.f.Float64Range(-0.02, 0.02),
+		MainsPower:        true,
+		BatteryPercent:    e.batteryAt(ts),
+		BackhaulLatencyMs: e.latencyMs * e.f.Float64Range(0.8, 1.5),
+		Timestamp:         ts,
+	}
+
+	progress := e.progress(ts)
+	switch e.state {
+	case equipmentDegrading:
+		switch e.cause {
+		case outageOverheat:
+			r.PaTemperatureC += progress * 45
+			r.ForwardPowerW *= 1 - progress*0.5 // Thermal foldback
+		case outageAntenna:
+			r.Vswr += progress * 2.5
+		case outagePower:
+			r.MainsPower = false
+		case outageBackhaul:
+			r.BackhaulLatencyMs += progress * progress * 800
+		}
+	case equipmentOutage:
+		r.ForwardPowerW = 0
+		switch e.cause {
+		case outageOverheat:
+			r.PaTemperatureC += (1 - progress) * 45 // Cooling down while the PA is off
+		case outageAntenna:
+			r.Vswr += 2.5
+		case outagePower:
+			r.MainsPower = false
+			r.PaTemperatureC = e.f.Float64Range(20, 25) // No power at all
+		case outageBackhaul:
+			r.BackhaulLatencyMs = math.NaN()
+		}
+	}
+	r.ReflectedPowerW = r.ForwardPowerW * math.Pow((r.Vswr-1)/(r.Vswr+1), 2)
+	return r
+}
+
+// emitEquipmentReadings advances the site equipment through the [from, to) time range
+// and emits a reading every interval, continuing from the last reading of the previous range.
+// The state machine is advanced at the start of the range and at every reading, each time over the duration
+// until the next advance, so the outage odds don't depend on the intervals, and outages are simulated
+// even if readings are disabled (interval <= 0).
+// Alarms raised or cleared by the state changes are emitted whether or not readings are enabled.
+func emitEquipmentReadings(h Handler, e *siteEquipment, from, to time.Time, interval time.Duration) error {
+	readings := interval > 0
+	if readings && e.nextReading.Before(from) { // First range, or nothing was generated while paused
+		e.nextReading = from
+	}
+	for ts := from; ts.Before(to); {
+		reading := readings && ts.Equal(e.nextReading)
+		if reading {
+			e.nextReading = ts.Add(interval)
+		}
+		next := to
+		if readings && e.nextReading.Before(to) {
+			next = e.nextReading
+		}
+		if e.advance(ts, next.Sub(ts)) {
+			for _, a := range e.updateAlarms(ts) {
+				alarm := *a // Alarms are updated when cleared, so handlers get a copy of the current state
+				if err := h.HandleRow(Row{TenantId: e.tenantId, Value: &alarm}); err != nil {
+					return err
+				}
+			}
+		}
+		if reading {
+			if err := h.HandleRow(Row{TenantId: e.tenantId, Value: e.reading(ts)}); err != nil {
+				return err
+			}
+		}
+		ts = next
+	}
+	return nil
+}
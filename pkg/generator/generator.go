@@ -0,0 +1,164 @@
+// Package generator generates simulated EI static records (topology) and metrics.
+//
+// The TopologyGenerator generates tenants, regions, sites and their channels, fleets, talk groups, units and consoles.
+// The CallGenerator then generates metrics (calls, channel readings, site equipment readings, alarms, messages,
+// console sessions, talk group patches and unit registrations) over a topology, either historically between
+// Config.Start and Config.End or in real time, and hands them over as rows to a Handler.
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/bandplan"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// Config is the settings of the generators.
+type Config struct {
+	// Topology
+	Tenants              []TenantConfig // Tenants to generate, zero values of the regions fall back to the per site settings below
+	ChannelsPerSite      int
+	FleetsPerSite        int
+	TalkGroupsPerSite    int
+	UnitsPerTalkGroup    int
+	ConsolesPerFleet     int
+	MinChannelsPerSite   int // Sites are never pruned below these minimum counts
+	MinFleetsPerSite     int
+	MinTalkGroupsPerSite int
+	MinUnitsPerTalkGroup int
+	QualityTiers         []QualityTier
+	BandPlan             bandplan.BandPlan
+
+	// Metrics
+	Start                time.Time // Historical metrics are generated in the [Start, End) time range
+	End                  time.Time
+	Interval             time.Duration // Duration of each generation step
+	EquipmentInterval    time.Duration // Interval between site equipment readings, 0 to disable them
+	MinLoad              float64       // Load factor range of sites without load profile from their region
+	MaxLoad              float64
+	RoamingRate          float64 // Probability of a unit roaming to another site at each step
+	OutagesPerDay        float64 // Average number of outages per site per day
+	MessagesPerUnitHour  float64 // Average number of messages sent by a unit per hour
+	ConsoleCallsPerHour  float64 // Average number of calls made from a console per hour
+	PatchesPerConsoleDay float64 // Average number of talk group patches made from a console per day
+
+	// Live
+	Speed     float64       // How many times faster than the wall clock the live clock runs, 0 for real time
+	LiveStart time.Time     // Time the live clock starts at, zero for now
+	Controls  *LiveControls // Optional parameters changed while the live generation runs, applied on each tick
+
+	// Generation
+	Seed    int64 // Seed of the random sources, so the same seed and settings generate the same data. 0 for a random seed
+	Workers int   // Number of CallGenerators the sites are partitioned into by NewCallGenerators
+}
+
+// DefaultConfig returns the default settings, which generates a single site from 2022-01-01T00:00:00Z for 1 hour.
+func DefaultConfig() Config {
+	return Config{
+		Tenants:              []TenantConfig{{Regions: []RegionConfig{{Sites: 1}}}},
+		ChannelsPerSite:      10,
+		FleetsPerSite:        5,
+		TalkGroupsPerSite:    20,
+		UnitsPerTalkGroup:    5,
+		ConsolesPerFleet:     1,
+		MinChannelsPerSite:   1,
+		MinFleetsPerSite:     1,
+		MinTalkGroupsPerSite: 1,
+		MinUnitsPerTalkGroup: 1,
+		QualityTiers:         DefaultQualityTiers(0.1),
+		BandPlan:             bandplan.Presets["uhf"],
+		Start:                time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:                  time.Date(2022, 1, 1, 1, 0, 1, 0, time.UTC),
+		Interval:             10 * time.Second,
+		EquipmentInterval:    5 * time.Second,
+		MinLoad:              0,
+		MaxLoad:              1,
+		OutagesPerDay:        1,
+		MessagesPerUnitHour:  2,
+		ConsoleCallsPerHour:  20,
+		PatchesPerConsoleDay: 4,
+		Workers:              1,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if c.Speed < 0 {
+		return fmt.Errorf("speed must not be negative")
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("number of workers must be positive")
+	}
+	if c.MinChannelsPerSite < 0 || c.MinFleetsPerSite < 0 || c.MinTalkGroupsPerSite < 0 || c.MinUnitsPerTalkGroup < 0 {
+		return fmt.Errorf("minimum number of entities must not be negative")
+	}
+	if c.MinLoad < 0 || c.MinLoad > c.MaxLoad {
+		return fmt.Errorf("invalid load factors: min=%f, max=%f", c.MinLoad, c.MaxLoad)
+	}
+	if err := validateQualityTiers(c.QualityTiers); err != nil {
+		return err
+	}
+	return c.BandPlan.Validate()
+}
+
+// Row is a generated record of a tenant.
+// Value is one of *model.Call, *model.ChannelReading, *model.SiteEquipmentReading, *model.Alarm, *model.Message,
+// *model.ConsoleSession, *model.TalkGroupPatch or *model.UnitRegistration.
+type Row struct {
+	TenantId string
+	Value    interface{}
+}
+
+// Handler receives the generated rows. Returning an error stops the generation.
+// The *model.Call values are reused by the next steps, so they must be copied to be kept after HandleRow returns.
+type Handler interface {
+	HandleRow(r Row) error
+	// HandleStep is called after all rows of the [from, to) step were handled.
+	HandleStep(from, to time.Time) error
+}
+
+// HandlerFunc is a Handler receiving rows only.
+type HandlerFunc func(r Row) error
+
+func (f HandlerFunc) HandleRow(r Row) error {
+	return f(r)
+}
+
+func (f HandlerFunc) HandleStep(from, to time.Time) error {
+	return nil
+}
+
+// Channel runs the generation in background and streams the generated rows, e.g.:
+//
+//	rows, errc := generator.Channel(ctx, calls.Run)
+//	for r := range rows {
+//		...
+//	}
+//	err := <-errc
+//
+// The rows channel is closed when the generation is done, then its error (if any) is sent to the error channel.
+func Channel(ctx context.Context, run func(ctx context.Context, h Handler) error) (<-chan Row, <-chan error) {
+	rows, errc := make(chan Row), make(chan error, 1)
+	go func() {
+		defer close(errc)
+		err := run(ctx, HandlerFunc(func(r Row) error {
+			if c, ok := r.Value.(*model.Call); ok {
+				call := *c // Calls are reused by the generator
+				r.Value = &call
+			}
+			select {
+			case rows <- r:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}))
+		close(rows)
+		errc <- err
+	}()
+	return rows, errc
+}
@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// recorder records the generated rows as text, and the number of rows at the end of each step.
+type recorder struct {
+	rows  []string
+	steps []int
+	// onStep is called after each step is recorded, returning an error stops the generation
+	onStep func(step int) error
+}
+
+func (r *recorder) HandleRow(row Row) error {
+	r.rows = append(r.rows, fmt.Sprintf("%s %T %+v", row.TenantId, row.Value, row.Value))
+	return nil
+}
+
+func (r *recorder) HandleStep(from, to time.Time) error {
+	r.steps = append(r.steps, len(r.rows))
+	if r.onStep != nil {
+		return r.onStep(len(r.steps))
+	}
+	return nil
+}
+
+func testConfig(seed int64) Config {
+	cfg := DefaultConfig()
+	cfg.Seed = seed
+	cfg.Tenants = []TenantConfig{{Regions: []RegionConfig{{Sites: 3}}}}
+	cfg.End = cfg.Start.Add(2 * time.Hour)
+	cfg.EquipmentInterval = 45 * time.Second // Not aligned with the steps, so readings carry over steps
+	cfg.RoamingRate = 0.05
+	cfg.OutagesPerDay = 24 // So the equipment state changes within the run
+	return cfg
+}
+
+// newTestGenerator generates the topology of the cfg, and returns a CallGenerator of it.
+func newTestGenerator(t *testing.T, cfg Config) *CallGenerator {
+	t.Helper()
+	topology, err := NewTopologyGenerator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := topology.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewCallGenerator(cfg, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func generate(t *testing.T, cfg Config) *recorder {
+	t.Helper()
+	r := &recorder{}
+	if err := newTestGenerator(t, cfg).Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func assertSameRows(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %d rows, want %d rows", len(got), len(want))
+	}
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if got[i] != want[i] {
+			t.Fatalf("row %d differs:\ngot:  %s\nwant: %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSameSeedGeneratesSameRows(t *testing.T) {
+	first := generate(t, testConfig(42))
+	if len(first.rows) == 0 {
+		t.Fatal("no row generated")
+	}
+	second := generate(t, testConfig(42))
+	assertSameRows(t, second.rows, first.rows)
+
+	other := generate(t, testConfig(43))
+	if len(other.rows) == len(first.rows) && other.rows[0] == first.rows[0] {
+		t.Error("another seed generated the same rows")
+	}
+}
+
+func TestRestoreContinuesGeneration(t *testing.T) {
+	cfg := testConfig(42)
+	want := generate(t, cfg)
+
+	// Interrupted in the middle of the run, with the state saved like a checkpoint
+	const steps = 300
+	errStop := errors.New("stop")
+	var saved []byte
+	interrupted := &recorder{}
+	g := newTestGenerator(t, cfg)
+	interrupted.onStep = func(step int) error {
+		if step < steps {
+			return nil
+		}
+		var err error
+		if saved, err = json.Marshal(g.State()); err != nil {
+			t.Fatal(err)
+		}
+		return errStop
+	}
+	if err := g.Run(context.Background(), interrupted); !errors.Is(err, errStop) {
+		t.Fatalf("got error %v, want the generation stopped", err)
+	}
+	assertSameRows(t, interrupted.rows, want.rows[:want.steps[steps-1]])
+
+	// Resumed by a new generator of the same topology
+	var state State
+	if err := json.Unmarshal(saved, &state); err != nil {
+		t.Fatal(err)
+	}
+	g = newTestGenerator(t, cfg)
+	if err := g.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	resumed := &recorder{}
+	if err := g.Run(context.Background(), resumed); err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed.steps) != len(want.steps)-steps {
+		t.Errorf("resumed %d steps, want %d steps", len(resumed.steps), len(want.steps)-steps)
+	}
+	assertSameRows(t, resumed.rows, want.rows[want.steps[steps-1]:])
+}
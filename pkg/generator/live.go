@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"context"
+	"time"
+)
+
+// clock is the simulated clock of the live mode, running speed times faster than the wall clock from start.
+type clock struct {
+	start     time.Time
+	wallStart time.Time
+	speed     float64
+}
+
+func newClock(start time.Time, speed float64) clock {
+	if speed == 0 {
+		speed = 1
+	}
+	return clock{start: start, wallStart: time.Now(), speed: speed}
+}
+
+func (c clock) now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.wallStart)) * c.speed))
+}
+
+// tick returns the wall clock duration between two ticks of the interval.
+func (c clock) tick(interval time.Duration) time.Duration {
+	d := time.Duration(float64(interval) / c.speed)
+	if d < time.Millisecond {
+		return time.Millisecond
+	}
+	return d
+}
+
+// RunLive generates the metrics in real time until the ctx is done.
+// At every Config.Interval tick, the metrics since the last tick are generated and calls last at most 5 minutes.
+// The live clock starts at Config.LiveStart (or now) and runs Config.Speed times faster than the wall clock,
+// so the ticks are Config.Interval/Config.Speed apart.
+func (g *CallGenerator) RunLive(ctx context.Context, h Handler) error {
+	start := g.cfg.LiveStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	g.next = start
+	return g.runLive(ctx, h, newClock(start, g.cfg.Speed))
+}
+
+// RunBackfillLive generates the historical metrics from Config.Start (or the restored State) up to now
+// as fast as possible, then continues generating the metrics like RunLive until the ctx is done,
+// so there's no gap between the historical and live metrics.
+// Config.End and Config.LiveStart are ignored.
+func (g *CallGenerator) RunBackfillLive(ctx context.Context, h Handler) error {
+	// Now keeps moving while backfilling, so the backfill only stops when the next step would end in the future
+	for ts := g.next; !ts.Add(g.cfg.Interval).After(time.Now()); ts = g.next {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+		if err := g.Step(ts, ts.Add(g.cfg.Interval), h); err != nil {
+			return err
+		}
+	}
+	return g.runLive(ctx, h, newClock(g.next, g.cfg.Speed))
+}
+
+// runLive generates the metrics between the last step and every tick of the live clock.
+// The Config.Controls (if any) are applied at every tick.
+func (g *CallGenerator) runLive(ctx context.Context, h Handler, c clock) error {
+	g.applyControls()
+	interval := g.cfg.Interval
+	ticker := time.NewTicker(c.tick(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			paused := g.applyControls()
+			if g.cfg.Interval != interval {
+				interval = g.cfg.Interval
+				ticker.Reset(c.tick(interval))
+			}
+			now := c.now()
+			if paused {
+				g.next = now // Nothing is generated for the paused time
+				continue
+			}
+			if err := g.step(h, g.next, now, now, 5*time.Minute, false); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"math"
+	"time"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// newUnitMessageRate randomizes the average number of messages per hour a unit sends,
+// so some units are chatty while the others barely send any message.
+func newUnitMessageRate(f *fake.Faker, messagesPerUnitHour float64) float64 {
+	return f.Float64Range(0, 2*messagesPerUnitHour)
+}
+
+// emitUnitMessages generates the short data/status messages sent in the [from, to) time range
+// by the units registered to the site.
+// Each unit sends messages at its own rate, so the number of messages of a unit follows a Poisson distribution.
+func emitUnitMessages(f *fake.Faker, h Handler, site *model.Site, from, to time.Time, messagesPerUnitHour float64) error {
+	if messagesPerUnitHour <= 0 || len(site.TalkGroups) == 0 {
+		return nil
+	}
+
+	hours := to.Sub(from).Hours()
+	for _, unit := range site.RegisteredUnits {
+		for i := poisson(f, unit.MessagesPerHour*hours); i > 0; i-- {
+			m := newUnitMessage(f, site, unit, f.DateRange(from, to))
+			if err := h.HandleRow(Row{TenantId: site.TenantId, Value: m}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newUnitMessage(f *fake.Faker, site *model.Site, unit *model.Unit, sentAt time.Time) *model.Message {
+	m := &model.Message{
+		Id:             f.UUID(),
+		SiteId:         site.Id,
+		SourceUnitId:   unit.Id,
+		DeliveryStatus: model.MessageDelivered,
+		LatencyMs:      int64(f.Float64Range(50, 500)),
+		SentAt:         sentAt,
+	}
+	if site.Poor {
+		m.LatencyMs *= 3
+	}
+
+	switch p := f.Float64Range(0, 1.0); {
+	case p < 0.5: // Status messages to the unit's talk group, usually read by the dispatcher
+		m.Type = model.MessageTypeStatus
+		m.PayloadBytes = 2
+		m.DestinationTalkGroupId = unit.TalkGroupId
+	case p < 0.8: // Location reports to a random talk group
+		m.Type = model.MessageTypeLocation
+		m.PayloadBytes = int64(f.IntRange(20, 30))
+		m.DestinationTalkGroupId = site.TalkGroups[f.IntRange(0, len(site.TalkGroups)-1)].Id
+	default: // Text messages to another unit nearby
+		m.Type = model.MessageTypeText
+		m.PayloadBytes = int64(f.IntRange(1, 140))
+		m.DestinationUnitId = site.RegisteredUnits[f.IntRange(0, len(site.RegisteredUnits)-1)].Id
+	}
+
+	failureRate := 0.02
+	if site.Poor {
+		failureRate = 0.1
+	}
+	switch p := f.Float64Range(0, 1.0); {
+	case p < failureRate:
+		m.DeliveryStatus = model.MessageFailed
+		m.LatencyMs = 0
+	case p < failureRate*1.5: // Undelivered until the message is expired
+		m.DeliveryStatus = model.MessageExpired
+		m.LatencyMs = 0
+	}
+	return m
+}
+
+// poisson returns a random number of events following the Poisson distribution with the lambda mean.
+func poisson(f *fake.Faker, lambda float64) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 { // Normal approximation (Box-Muller), as Knuth's algorithm is too slow for big lambda
+		z := math.Sqrt(-2*math.Log(1-f.Float64Range(0, 1.0))) * math.Cos(2*math.Pi*f.Float64Range(0, 1.0))
+		return int(math.Max(math.Round(lambda+math.Sqrt(lambda)*z), 0))
+	}
+	l, k, p := math.Exp(-lambda), 0, 1.0
+	for {
+		p *= f.Float64Range(0, 1.0)
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"fmt"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+)
+
+// QualityTier is the share of generated sites in the tier and how many of their entities are pruned.
+// Each site of the tier prunes its entities with a random rate in [0, max*PruneRate].
+type QualityTier struct {
+	Name                  string  `json:"name"`
+	Share                 float64 `json:"share"` // Relative weight of the tier, shares don't need to sum up to 1
+	Poor                  bool    `json:"poor"`  // Sites of poor tiers have degraded radio signal quality and more outages
+	MaxFleetPruneRate     float64 `json:"maxFleetPruneRate"`
+	MaxChannelPruneRate   float64 `json:"maxChannelPruneRate"`
+	MaxTalkGroupPruneRate float64 `json:"maxTalkGroupPruneRate"`
+	MaxUnitPruneRate      float64 `json:"maxUnitPruneRate"`
+}
+
+// DefaultQualityTiers returns the normal tier and the poor tier with poorSiteRate share,
+// poor sites have 0%-10% less fleets and channels, 0%-15% less talk groups and 0%-20% less units.
+func DefaultQualityTiers(poorSiteRate float64) []QualityTier {
+	return []QualityTier{
+		{Name: "normal", Share: 1 - poorSiteRate},
+		{
+			Name:                  "poor",
+			Share:                 poorSiteRate,
+			Poor:                  true,
+			MaxFleetPruneRate:     0.1,
+			MaxChannelPruneRate:   0.1,
+			MaxTalkGroupPruneRate: 0.15,
+			MaxUnitPruneRate:      0.2,
+		},
+	}
+}
+
+func validateQualityTiers(tiers []QualityTier) error {
+	totalShare := 0.0
+	for _, t := range tiers {
+		if t.Share < 0 {
+			return fmt.Errorf("invalid share of quality tier %q: %f", t.Name, t.Share)
+		}
+		for _, rate := range []float64{t.MaxFleetPruneRate, t.MaxChannelPruneRate, t.MaxTalkGroupPruneRate, t.MaxUnitPruneRate} {
+			if rate < 0 || rate > 1 {
+				return fmt.Errorf("invalid prune rate of quality tier %q: %f, must be in [0, 1]", t.Name, rate)
+			}
+		}
+		totalShare += t.Share
+	}
+	if totalShare <= 0 {
+		return fmt.Errorf("no quality tier with positive share found")
+	}
+	return nil
+}
+
+// pickQualityTier randomly picks a tier weighted by the tiers' shares.
+func pickQualityTier(f *fake.Faker, tiers []QualityTier) QualityTier {
+	totalShare := 0.0
+	for _, t := range tiers {
+		totalShare += t.Share
+	}
+	r := f.Float64Range(0, totalShare)
+	for _, t := range tiers {
+		if r < t.Share {
+			return t
+		}
+		r -= t.Share
+	}
+	return tiers[len(tiers)-1]
+}
+
+// pruner randomly prunes entities of a kind with a fixed rate, while keeping at least min entities.
+type pruner struct {
+	f     *fake.Faker
+	rate  float64
+	min   int
+	total int // Number of entities to generate before pruning
+	kept  int
+	seen  int
+}
+
+func newPruner(f *fake.Faker, maxRate float64, total, min int) *pruner {
+	return &pruner{f: f, rate: f.Float64Range(0, maxRate), min: min, total: total}
+}
+
+// prune returns true if the next entity should be skipped.
+// Entities are never pruned when the remaining ones are needed to reach the min count.
+func (p *pruner) prune() bool {
+	remaining := p.total - p.seen
+	p.seen++
+	if p.kept+remaining > p.min && p.rate > 0 && p.f.Float64Range(0, 1.0) < p.rate {
+		return true
+	}
+	p.kept++
+	return false
+}
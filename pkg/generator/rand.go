@@ -0,0 +1,43 @@
+package generator
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+)
+
+// source is a splitmix64 random source. Its whole state is a single number,
+// so the state of a CallGenerator can be saved and restored exactly.
+type source struct {
+	state uint64
+}
+
+// newSource returns a source seeded by the seed, or by a random seed if the seed is 0.
+func newSource(seed int64) *source {
+	if seed == 0 {
+		_ = binary.Read(crand.Reader, binary.BigEndian, &seed)
+	}
+	return &source{state: uint64(seed)}
+}
+
+func (s *source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// resetRand discards the random bytes buffered by the rand.Rand of the faker for its Read method (e.g. for UUIDs),
+// so the source is the whole random state of the CallGenerator between steps.
+func (g *CallGenerator) resetRand() {
+	g.f.Rand = rand.New(g.src)
+}
@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"math"
+	"time"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// newChannelReadings randomizes the RF conditions of all channels of a site at the ts time.
+// The returned readings have the same order as site.Channels.
+func newChannelReadings(f *fake.Faker, site *model.Site, ts time.Time) []*model.ChannelReading {
+	readings := make([]*model.ChannelReading, 0, len(site.Channels))
+	for _, channel := range site.Channels {
+		r := &model.ChannelReading{
+			ChannelId:       channel.Id,
+			SiteId:          site.Id,
+			NoiseFloorDbm:   f.Float64Range(-120, -110),
+			InterferenceDbm: f.Float64Range(-130, -115),
+			Timestamp:       ts,
+		}
+		if site.Poor { // Poor sites are noisier and suffer more interference
+			r.NoiseFloorDbm = f.Float64Range(-110, -95)
+			r.InterferenceDbm = f.Float64Range(-115, -90)
+		}
+		readings = append(readings, r)
+	}
+	return readings
+}
+
+// applyCallSignalQuality randomizes the radio signal quality of the c call
+// based on the RF conditions of the channel the call was made on.
+func applyCallSignalQuality(f *fake.Faker, c *model.Call, site *model.Site, r *model.ChannelReading) {
+	c.RssiDbm = f.Float64Range(-95, -60)
+	if site.Poor {
+		c.RssiDbm = f.Float64Range(-115, -85)
+	}
+	c.SnrDb = math.Max(c.RssiDbm-math.Max(r.NoiseFloorDbm, r.InterferenceDbm), 0)
+	c.BerPercent = math.Min(50*math.Exp(-c.SnrDb/4), 50) // SNR 20dB ~ 0.3%, SNR 5dB ~ 14%
+	c.AudioQuality = math.Max(math.Min(4.5-c.BerPercent*0.25+f.Float64Range(-0.3, 0.3), 5), 1)
+	r.Calls++
+}
+
+// emitChannelReadings emits the readings of all channels of a site.
+// The channel utilisation is estimated from the share of the site's registered units making a call on the channel.
+func emitChannelReadings(h Handler, site *model.Site, readings []*model.ChannelReading) error {
+	for _, r := range readings {
+		if len(site.RegisteredUnits) > 0 {
+			r.UtilisationPercent = math.Min(float64(r.Calls*len(readings))/float64(len(site.RegisteredUnits))*100, 100)
+		}
+		if err := h.HandleRow(Row{TenantId: site.TenantId, Value: r}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
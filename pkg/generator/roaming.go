@@ -0,0 +1,91 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// initUnitRegistrations registers all units to the site they're currently on.
+// Units without a known current site are registered back to their home site.
+// It returns the number of units currently roaming.
+func initUnitRegistrations(sites []*model.Site) int {
+	siteMap := make(map[string]*model.Site, len(sites))
+	for _, site := range sites {
+		siteMap[site.Id] = site
+		site.RegisteredUnits = make([]*model.Unit, 0, len(site.Units))
+	}
+
+	roamingUnits := 0
+	for _, site := range sites {
+		for _, unit := range site.Units {
+			currentSite, ok := siteMap[unit.CurrentSiteId]
+			if !ok {
+				currentSite = site
+			}
+			unit.CurrentSiteId = currentSite.Id
+			currentSite.RegisteredUnits = append(currentSite.RegisteredUnits, unit)
+			if currentSite.Id != unit.SiteId {
+				roamingUnits++
+			}
+		}
+	}
+	return roamingUnits
+}
+
+// roamUnits randomly hands over registered units to other sites of the same tenant and emits
+// a unit registration for each handover.
+// A roaming unit has 50% chance to return to its home site on its next handover.
+func (g *CallGenerator) roamUnits(h Handler, now time.Time) error {
+	if g.cfg.RoamingRate <= 0 || len(g.sites) < 2 {
+		return nil
+	}
+
+	// Decide all handovers first, so a unit can only roam once per interval
+	type handover struct {
+		unit         *model.Unit
+		registration model.UnitRegistration
+	}
+	handovers := make([]handover, 0)
+	for _, site := range g.sites {
+		registeredUnits := site.RegisteredUnits[:0]
+		for _, unit := range site.RegisteredUnits {
+			if g.f.Float64Range(0, 1.0) >= g.cfg.RoamingRate {
+				registeredUnits = append(registeredUnits, unit)
+				continue
+			}
+
+			candidates := g.tenantSites[site.TenantId]
+			nextSite := candidates[g.f.IntRange(0, len(candidates)-1)]
+			if unit.CurrentSiteId != unit.SiteId && g.f.Bool() {
+				nextSite = g.siteMap[unit.SiteId] // Back to home site
+			}
+			if nextSite.Id == site.Id {
+				registeredUnits = append(registeredUnits, unit) // Stay on the current site
+				continue
+			}
+			handovers = append(handovers, handover{
+				unit: unit,
+				registration: model.UnitRegistration{
+					UnitId:         unit.Id,
+					SiteId:         nextSite.Id,
+					PreviousSiteId: site.Id,
+					HomeSiteId:     unit.SiteId,
+					RegisteredAt:   now,
+				},
+			})
+		}
+		site.RegisteredUnits = registeredUnits
+	}
+
+	for _, ho := range handovers {
+		r := ho.registration
+		ho.unit.CurrentSiteId = r.SiteId
+		nextSite := g.siteMap[r.SiteId]
+		nextSite.RegisteredUnits = append(nextSite.RegisteredUnits, ho.unit)
+		if err := h.HandleRow(Row{TenantId: nextSite.TenantId, Value: &r}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// State is the simulation state of a CallGenerator between two historical steps.
+// A State saved after a step and restored to a new CallGenerator of the same sites continues the generation
+// with the next step, generating the same rows as if the generation was never interrupted.
+type State struct {
+	Next            time.Time   `json:"next"` // Start of the next step
+	Rand            uint64      `json:"rand"`
+	ConsolesStarted bool        `json:"consolesStarted"`
+	Sites           []SiteState `json:"sites"`
+}
+
+type SiteState struct {
+	Id              string         `json:"id"`
+	RegisteredUnits []string       `json:"registeredUnits"` // IDs of the units registered to the site, in order
+	Equipment       EquipmentState `json:"equipment"`
+	Consoles        []ConsoleState `json:"consoles,omitempty"`
+}
+
+type EquipmentState struct {
+	State         string        `json:"state"`
+	Cause         string        `json:"cause,omitempty"`
+	StateSince    time.Time     `json:"stateSince"`
+	StateUntil    time.Time     `json:"stateUntil"`
+	Battery       float64       `json:"battery"`
+	Alarms        []model.Alarm `json:"alarms,omitempty"` // Active alarms
+	TemperatureC  float64       `json:"temperatureC"`
+	ForwardPowerW float64       `json:"forwardPowerW"`
+	Vswr          float64       `json:"vswr"`
+	LatencyMs     float64       `json:"latencyMs"`
+	NextReading   time.Time     `json:"nextReading"`
+}
+
+type ConsoleState struct {
+	Id         string                 `json:"id"`
+	Session    *model.ConsoleSession  `json:"session,omitempty"` // Nil while no dispatcher is logged in
+	NextLogin  time.Time              `json:"nextLogin"`
+	SessionEnd time.Time              `json:"sessionEnd"`
+	Patches    []model.TalkGroupPatch `json:"patches,omitempty"` // Active patches
+	PatchEnds  []time.Time            `json:"patchEnds,omitempty"`
+}
+
+// State returns a copy of the current state, it must be called between steps (e.g. in Handler.HandleStep).
+func (g *CallGenerator) State() State {
+	s := State{
+		Next:            g.next,
+		Rand:            g.src.state,
+		ConsolesStarted: g.consoles != nil,
+		Sites:           make([]SiteState, 0, len(g.sites)),
+	}
+	for _, site := range g.sites {
+		ss := SiteState{Id: site.Id, RegisteredUnits: make([]string, 0, len(site.RegisteredUnits))}
+		for _, unit := range site.RegisteredUnits {
+			ss.RegisteredUnits = append(ss.RegisteredUnits, unit.Id)
+		}
+
+		e := g.equipments[site.Id]
+		ss.Equipment = EquipmentState{
+			State:         e.state,
+			Cause:         e.cause,
+			StateSince:    e.stateSince,
+			StateUntil:    e.stateUntil,
+			Battery:       e.battery,
+			TemperatureC:  e.temperatureC,
+			ForwardPowerW: e.forwardPowerW,
+			Vswr:          e.vswr,
+			LatencyMs:     e.latencyMs,
+			NextReading:   e.nextReading,
+		}
+		for _, a := range e.alarms {
+			ss.Equipment.Alarms = append(ss.Equipment.Alarms, *a)
+		}
+
+		for _, a := range g.consoles[site.Id] {
+			cs := ConsoleState{
+				Id:         a.console.Id,
+				NextLogin:  a.nextLogin,
+				SessionEnd: a.sessionEnd,
+				PatchEnds:  append([]time.Time(nil), a.patchEnds...),
+			}
+			if a.session != nil {
+				session := *a.session
+				cs.Session = &session
+			}
+			for _, p := range a.patches {
+				cs.Patches = append(cs.Patches, *p)
+			}
+			ss.Consoles = append(ss.Consoles, cs)
+		}
+		s.Sites = append(s.Sites, ss)
+	}
+	return s
+}
+
+// Restore restores a State saved from a CallGenerator of the same sites, e.g. the same worker of
+// NewCallGenerators with the same Config.Workers.
+func (g *CallGenerator) Restore(s State) error {
+	if len(s.Sites) != len(g.sites) {
+		return fmt.Errorf("state has %d sites, but the generator has %d sites", len(s.Sites), len(g.sites))
+	}
+	units := make(map[string]*model.Unit)
+	for _, site := range g.sites {
+		for _, unit := range site.Units {
+			units[unit.Id] = unit
+		}
+	}
+
+	for i, ss := range s.Sites {
+		site := g.sites[i]
+		if ss.Id != site.Id {
+			return fmt.Errorf("unknown site %s in state, expected site %s", ss.Id, site.Id)
+		}
+		site.RegisteredUnits = make([]*model.Unit, 0, len(ss.RegisteredUnits))
+		for _, id := range ss.RegisteredUnits {
+			unit, ok := units[id]
+			if !ok {
+				return fmt.Errorf("unknown unit %s registered to site %s in state", id, site.Id)
+			}
+			unit.CurrentSiteId = site.Id
+			site.RegisteredUnits = append(site.RegisteredUnits, unit)
+		}
+
+		e := g.equipments[site.Id]
+		e.state, e.cause = ss.Equipment.State, ss.Equipment.Cause
+		e.stateSince, e.stateUntil = ss.Equipment.StateSince, ss.Equipment.StateUntil
+		e.battery = ss.Equipment.Battery
+		e.temperatureC, e.forwardPowerW = ss.Equipment.TemperatureC, ss.Equipment.ForwardPowerW
+		e.vswr, e.latencyMs = ss.Equipment.Vswr, ss.Equipment.LatencyMs
+		e.nextReading = ss.Equipment.NextReading
+		e.alarms = nil
+		for j := range ss.Equipment.Alarms {
+			alarm := ss.Equipment.Alarms[j]
+			e.alarms = append(e.alarms, &alarm)
+		}
+	}
+
+	g.consoles = nil
+	if s.ConsolesStarted {
+		g.consoles = newConsoleActivities(g.f, g.sites, s.Next) // Talk groups of the consoles, the rest is restored
+		for i, ss := range s.Sites {
+			activities := g.consoles[g.sites[i].Id]
+			if len(ss.Consoles) != len(activities) {
+				return fmt.Errorf("state has %d consoles of site %s, but the site has %d consoles", len(ss.Consoles), ss.Id, len(activities))
+			}
+			for j, cs := range ss.Consoles {
+				a := activities[j]
+				if cs.Id != a.console.Id {
+					return fmt.Errorf("unknown console %s in state, expected console %s", cs.Id, a.console.Id)
+				}
+				a.session, a.nextLogin, a.sessionEnd = cs.Session, cs.NextLogin, cs.SessionEnd
+				a.patches, a.patchEnds = nil, cs.PatchEnds
+				for k := range cs.Patches {
+					patch := cs.Patches[k]
+					a.patches = append(a.patches, &patch)
+				}
+			}
+		}
+	}
+
+	g.next = s.Next
+	g.src.state = s.Rand // Restored last, as creating the console activities draws random numbers
+	g.resetRand()
+	return nil
+}
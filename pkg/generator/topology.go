@@ -0,0 +1,327 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/bandplan"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// TenantConfig is the name and regions of a tenant.
+type TenantConfig struct {
+	Name    string         `json:"name"`
+	Regions []RegionConfig `json:"regions"`
+}
+
+// RegionConfig is the topology sizes and load profile of a region.
+// Zero values fall back to the corresponding settings of the Config.
+type RegionConfig struct {
+	Name                string  `json:"name"`
+	Sites               int     `json:"sites"`
+	ChannelsPerSite     int     `json:"channelsPerSite"`
+	FleetsPerSite       int     `json:"fleetsPerSite"`
+	TalkGroupsPerSite   int     `json:"talkGroupsPerSite"`
+	UnitsPerTalkGroup   int     `json:"unitsPerTalkGroup"`
+	ConsolesPerFleet    int     `json:"consolesPerFleet"`
+	MinLoad             float64 `json:"minLoad"`
+	MaxLoad             float64 `json:"maxLoad"`
+	TimezoneOffsetHours int     `json:"timezoneOffsetHours"`
+}
+
+var nonSlugChars = regexp.MustCompile("[^a-z0-9_]+")
+
+// Slug returns the lower case name of a tenant, which is safe to be used in table and file names.
+func Slug(name string) string {
+	name = strings.TrimPrefix(name, "Tenant#")
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// Sites returns the sites of all tenants' regions, with the runtime region of each site set.
+func Sites(tenants []*model.Tenant) []*model.Site {
+	sites := make([]*model.Site, 0)
+	for _, t := range tenants {
+		for _, r := range t.Regions {
+			for _, site := range r.Sites {
+				site.Region = r
+				sites = append(sites, site)
+			}
+		}
+	}
+	return sites
+}
+
+// TopologyGenerator generates the static records: tenants, regions, sites, channels, fleets, talk groups, units and consoles.
+type TopologyGenerator struct {
+	cfg   Config
+	f     *fake.Faker
+	names map[string]int
+}
+
+// NewTopologyGenerator returns a TopologyGenerator, the same Config.Seed generates the same static records.
+func NewTopologyGenerator(cfg Config) (*TopologyGenerator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &TopologyGenerator{cfg: cfg, f: fake.NewUnlocked(cfg.Seed), names: make(map[string]int, 5000)}, nil
+}
+
+// Generate generates the tenants of the Config and all of their static records.
+func (g *TopologyGenerator) Generate() ([]*model.Tenant, error) {
+	if len(g.cfg.Tenants) == 0 {
+		return nil, fmt.Errorf("no tenant to generate")
+	}
+
+	tenants := make([]*model.Tenant, 0, len(g.cfg.Tenants))
+	slugs := make(map[string]bool, len(g.cfg.Tenants))
+	for i, tc := range g.cfg.Tenants {
+		if tc.Name == "" {
+			tc.Name = "Tenant#" + g.uniqueName(g.f.Company)
+		}
+		regions, err := g.withRegionDefaults(tc.Regions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regions of tenant %q: %w", tc.Name, err)
+		}
+		tenant := &model.Tenant{
+			Id:      g.f.UUID(),
+			Name:    tc.Name,
+			Slug:    Slug(tc.Name),
+			Status:  model.StatusActive,
+			Regions: make([]*model.Region, 0, len(regions)),
+		}
+		if tenant.Slug == "" || slugs[tenant.Slug] { // Slug must be unique to route tenants to their own tables or files
+			tenant.Slug += "_" + strconv.Itoa(i+1)
+		}
+		slugs[tenant.Slug] = true
+
+		for _, c := range regions {
+			region := &model.Region{
+				Id:                  g.f.UUID(),
+				TenantId:            tenant.Id,
+				Name:                c.Name,
+				Status:              model.StatusActive,
+				MinLoad:             c.MinLoad,
+				MaxLoad:             c.MaxLoad,
+				TimezoneOffsetHours: c.TimezoneOffsetHours,
+				Sites:               make([]*model.Site, 0, c.Sites),
+			}
+			for i := 0; i < c.Sites; i++ {
+				site, err := g.generateSite(region, c)
+				if err != nil {
+					return nil, err
+				}
+				region.Sites = append(region.Sites, site)
+			}
+			tenant.Regions = append(tenant.Regions, region)
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// withRegionDefaults returns the region configs with their zero values filled by the Config settings.
+func (g *TopologyGenerator) withRegionDefaults(configs []RegionConfig) ([]RegionConfig, error) {
+	configs = append([]RegionConfig{}, configs...)
+	for i := range configs {
+		c := &configs[i]
+		if c.Name == "" {
+			c.Name = "Region#" + g.uniqueName(g.f.City)
+		}
+		if c.ChannelsPerSite == 0 {
+			c.ChannelsPerSite = g.cfg.ChannelsPerSite
+		}
+		if c.FleetsPerSite == 0 {
+			c.FleetsPerSite = g.cfg.FleetsPerSite
+		}
+		if c.TalkGroupsPerSite == 0 {
+			c.TalkGroupsPerSite = g.cfg.TalkGroupsPerSite
+		}
+		if c.UnitsPerTalkGroup == 0 {
+			c.UnitsPerTalkGroup = g.cfg.UnitsPerTalkGroup
+		}
+		if c.ConsolesPerFleet == 0 {
+			c.ConsolesPerFleet = g.cfg.ConsolesPerFleet
+		}
+		if c.MaxLoad != 0 && (c.MinLoad < 0 || c.MinLoad > c.MaxLoad) {
+			return nil, fmt.Errorf("invalid load profile of region %q: minLoad=%f, maxLoad=%f", c.Name, c.MinLoad, c.MaxLoad)
+		}
+	}
+	return configs, nil
+}
+
+// generateSite generates a site and its channels, fleets, consoles, talk groups and units
+// with the topology sizes from the region config.
+func (g *TopologyGenerator) generateSite(region *model.Region, c RegionConfig) (*model.Site, error) {
+	siteId := g.f.UUID()
+	tier := pickQualityTier(g.f, g.cfg.QualityTiers) // Sites of lower tiers have some of their entities pruned
+
+	// Fleets of a site
+	noOfFleets := max(c.FleetsPerSite, g.cfg.MinFleetsPerSite)
+	fleets := make([]*model.Fleet, 0, noOfFleets)
+	fleetPruner := newPruner(g.f, tier.MaxFleetPruneRate, noOfFleets, g.cfg.MinFleetsPerSite)
+	for j := 0; j < noOfFleets; j++ {
+		if fleetPruner.prune() {
+			continue
+		}
+		fleets = append(fleets, g.newFleet(siteId))
+	}
+
+	// Dispatch consoles of a site
+	consoles := g.NewConsoles(siteId, fleets, c.ConsolesPerFleet)
+
+	// Channels of a site
+	noOfChannels := max(c.ChannelsPerSite, g.cfg.MinChannelsPerSite)
+	channels := make([]*model.Channel, 0, noOfChannels)
+	channelPruner := newPruner(g.f, tier.MaxChannelPruneRate, noOfChannels, g.cfg.MinChannelsPerSite)
+	frequencies := g.NewFrequencyAllocator() // No duplicated frequencies within a site
+	for j := 0; j < noOfChannels; j++ {
+		if channelPruner.prune() {
+			continue
+		}
+		channel, err := g.newChannel(siteId, frequencies)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate channel frequencies: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+
+	// TalkGroups of a site, a site without fleet can't have talk groups
+	noOfTalkGroups := max(c.TalkGroupsPerSite, g.cfg.MinTalkGroupsPerSite)
+	if len(fleets) == 0 {
+		noOfTalkGroups = 0
+	}
+	talkGroups := make([]*model.TalkGroup, 0, noOfTalkGroups)
+	noOfUnitsPerTalkGroup := max(c.UnitsPerTalkGroup, g.cfg.MinUnitsPerTalkGroup)
+	units := make([]*model.Unit, 0, noOfUnitsPerTalkGroup*noOfTalkGroups)
+	talkGroupPruner := newPruner(g.f, tier.MaxTalkGroupPruneRate, noOfTalkGroups, g.cfg.MinTalkGroupsPerSite)
+	for j := 0; j < noOfTalkGroups; j++ {
+		if talkGroupPruner.prune() {
+			continue
+		}
+		talkGroup := g.newTalkGroup(siteId, fleets[g.f.IntRange(0, len(fleets)-1)].Id) // Randomly assign talk group to a fleet
+
+		// Units per talk group
+		unitPruner := newPruner(g.f, tier.MaxUnitPruneRate, noOfUnitsPerTalkGroup, g.cfg.MinUnitsPerTalkGroup)
+		for k := 0; k < noOfUnitsPerTalkGroup; k++ {
+			if unitPruner.prune() {
+				continue
+			}
+			units = append(units, g.newUnit(siteId, talkGroup.Id))
+		}
+
+		talkGroups = append(talkGroups, talkGroup)
+	}
+
+	// Site
+	return &model.Site{
+		Id:          siteId,
+		TenantId:    region.TenantId,
+		RegionId:    region.Id,
+		Name:        "Site#" + g.uniqueName(g.f.Fruit),
+		Status:      model.StatusActive,
+		Poor:        tier.Poor,
+		QualityTier: tier.Name,
+		Channels:    channels,
+		Fleets:      fleets,
+		TalkGroups:  talkGroups,
+		Units:       units,
+		Consoles:    consoles,
+	}, nil
+}
+
+func (g *TopologyGenerator) newFleet(siteId string) *model.Fleet {
+	return &model.Fleet{
+		Id:     g.f.UUID(),
+		SiteId: siteId,
+		Name:   "Fleet#" + g.uniqueName(g.f.CountryAbr),
+		Status: model.StatusActive,
+	}
+}
+
+func (g *TopologyGenerator) newChannel(siteId string, frequencies *bandplan.Allocator) (*model.Channel, error) {
+	txFreq, rxFreq, err := frequencies.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	return &model.Channel{
+		Id:          g.f.UUID(),
+		SiteId:      siteId,
+		Name:        "Channel#" + g.uniqueName(g.f.Noun),
+		TxFrequency: txFreq,
+		RxFrequency: rxFreq,
+		Status:      model.StatusActive,
+	}, nil
+}
+
+func (g *TopologyGenerator) newTalkGroup(siteId, fleetId string) *model.TalkGroup {
+	return &model.TalkGroup{
+		Id:      g.f.UUID(),
+		SiteId:  siteId,
+		FleetId: fleetId,
+		Name:    "TalkGroup#" + g.uniqueName(g.f.LoremIpsumWord),
+		Status:  model.StatusActive,
+	}
+}
+
+func (g *TopologyGenerator) newUnit(siteId, talkGroupId string) *model.Unit {
+	return &model.Unit{
+		Id:              g.f.UUID(),
+		SiteId:          siteId,
+		TalkGroupId:     talkGroupId,
+		Name:            "Unit#" + g.uniqueName(g.f.Word),
+		Status:          model.StatusActive,
+		MessagesPerHour: newUnitMessageRate(g.f, g.cfg.MessagesPerUnitHour),
+	}
+}
+
+// NewFrequencyAllocator returns a channel frequency allocator of Config.BandPlan for a single site,
+// drawing from the random source of the TopologyGenerator.
+func (g *TopologyGenerator) NewFrequencyAllocator() *bandplan.Allocator {
+	return g.cfg.BandPlan.NewAllocator(g.f)
+}
+
+// NewConsoles generates consolesPerFleet dispatch consoles for each fleet of a site.
+func (g *TopologyGenerator) NewConsoles(siteId string, fleets []*model.Fleet, consolesPerFleet int) []*model.Console {
+	consoles := make([]*model.Console, 0, consolesPerFleet*len(fleets))
+	for _, fleet := range fleets {
+		for j := 0; j < consolesPerFleet; j++ {
+			consoles = append(consoles, &model.Console{
+				Id:      g.f.UUID(),
+				SiteId:  siteId,
+				FleetId: fleet.Id,
+				Name:    "Console#" + g.uniqueName(g.f.Animal),
+				Status:  model.StatusActive,
+			})
+		}
+	}
+	return consoles
+}
+
+func (g *TopologyGenerator) uniqueName(nameFunc func() string) string {
+	name := nameFunc()
+	if name == "" {
+		return g.f.UUID()
+	}
+	if len(name) == 1 {
+		return name + strconv.Itoa(int(g.f.Int64()))
+	}
+	name = strings.ReplaceAll(name, " ", "_")
+	name = strings.ToUpper(string(name[0])) + name[1:]
+	count, ok := g.names[name]
+	if ok {
+		g.names[name] = 1
+		return name
+	}
+	g.names[name] = count + 1
+	return name + strconv.Itoa(count+1)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
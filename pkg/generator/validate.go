@@ -0,0 +1,253 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// maxValidationErrors is the max number of validation errors reported at once.
+const maxValidationErrors = 20
+
+// ProblemsError returns an error listing the first problems of the kind, or nil if there is no problem.
+func ProblemsError(kind string, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	msgs := problems
+	if len(msgs) > maxValidationErrors {
+		msgs = msgs[:maxValidationErrors]
+	}
+	return fmt.Errorf("%d %s:\n  %s", len(problems), kind, strings.Join(msgs, "\n  "))
+}
+
+// Validate validates the topology of the tenants, so call metrics can be generated from it:
+//   - There is at least 1 site, and every site has channels, fleets, talk groups and units.
+//   - IDs are unique, and channel frequencies are unique within a site.
+//   - Every entity belongs to its site, talk groups and consoles belong to a fleet of the same site,
+//     and units belong to a talk group of the same site.
+func Validate(tenants []*model.Tenant) error {
+	problems := make([]string, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	sites := Sites(tenants)
+	if len(sites) == 0 {
+		add("no site found")
+	}
+	ids := make(map[string]bool)
+	isDuplicated := func(kind, id string) bool {
+		if ids[kind+"/"+id] {
+			return true
+		}
+		ids[kind+"/"+id] = true
+		return false
+	}
+	for _, site := range sites {
+		if isDuplicated("site", site.Id) {
+			add("duplicated site id %q", site.Id)
+		}
+		if site.Region != nil && site.TenantId != site.Region.TenantId {
+			add("site %q: tenant %q differs from its region's tenant %q", site.Id, site.TenantId, site.Region.TenantId)
+		}
+		if len(site.Channels) == 0 || len(site.Fleets) == 0 || len(site.TalkGroups) == 0 || len(site.Units) == 0 {
+			add("site %q: must have channels, fleets, talk groups and units, got %d channels, %d fleets, %d talk groups, %d units",
+				site.Id, len(site.Channels), len(site.Fleets), len(site.TalkGroups), len(site.Units))
+		}
+
+		fleets := make(map[string]bool, len(site.Fleets))
+		talkGroups := make(map[string]bool, len(site.TalkGroups))
+		txFrequencies := make(map[float64]bool, len(site.Channels))
+		rxFrequencies := make(map[float64]bool, len(site.Channels))
+		for _, c := range site.Channels {
+			if isDuplicated("channel", c.Id) {
+				add("site %q: duplicated channel id %q", site.Id, c.Id)
+			}
+			if c.SiteId != site.Id {
+				add("site %q: channel %q belongs to site %q", site.Id, c.Id, c.SiteId)
+			}
+			if txFrequencies[c.TxFrequency] || rxFrequencies[c.RxFrequency] {
+				add("site %q: channel %q duplicates the frequencies of another channel, tx=%g MHz, rx=%g MHz", site.Id, c.Id, c.TxFrequency, c.RxFrequency)
+			}
+			txFrequencies[c.TxFrequency], rxFrequencies[c.RxFrequency] = true, true
+		}
+		for _, f := range site.Fleets {
+			if isDuplicated("fleet", f.Id) {
+				add("site %q: duplicated fleet id %q", site.Id, f.Id)
+			}
+			if f.SiteId != site.Id {
+				add("site %q: fleet %q belongs to site %q", site.Id, f.Id, f.SiteId)
+			}
+			fleets[f.Id] = true
+		}
+		for _, tg := range site.TalkGroups {
+			if isDuplicated("talk group", tg.Id) {
+				add("site %q: duplicated talk group id %q", site.Id, tg.Id)
+			}
+			if tg.SiteId != site.Id {
+				add("site %q: talk group %q belongs to site %q", site.Id, tg.Id, tg.SiteId)
+			}
+			if !fleets[tg.FleetId] {
+				add("site %q: talk group %q references unknown fleet %q", site.Id, tg.Id, tg.FleetId)
+			}
+			talkGroups[tg.Id] = true
+		}
+		for _, u := range site.Units {
+			if isDuplicated("unit", u.Id) {
+				add("site %q: duplicated unit id %q", site.Id, u.Id)
+			}
+			if u.SiteId != site.Id {
+				add("site %q: unit %q belongs to site %q", site.Id, u.Id, u.SiteId)
+			}
+			if !talkGroups[u.TalkGroupId] {
+				add("site %q: unit %q references unknown talk group %q", site.Id, u.Id, u.TalkGroupId)
+			}
+		}
+		for _, c := range site.Consoles {
+			if isDuplicated("console", c.Id) {
+				add("site %q: duplicated console id %q", site.Id, c.Id)
+			}
+			if c.SiteId != site.Id {
+				add("site %q: console %q belongs to site %q", site.Id, c.Id, c.SiteId)
+			}
+			if !fleets[c.FleetId] {
+				add("site %q: console %q references unknown fleet %q", site.Id, c.Id, c.FleetId)
+			}
+		}
+	}
+	return ProblemsError("topology validation errors", problems)
+}
+
+// Repair fixes the topology of the tenants, so it passes Validate:
+//   - Duplicated IDs are replaced by new IDs, and entities are moved to the site they are listed in.
+//   - Channels duplicating the frequencies of another channel of the site get new frequencies from the band plan.
+//   - Talk groups, units and consoles with unknown references are reassigned to a random fleet or talk group of the site.
+//   - Sites without channels, fleets, talk groups or units get them generated from the Config settings.
+//
+// Returns the number of repaired entities. Tenants without any site can't be repaired.
+func (g *TopologyGenerator) Repair(tenants []*model.Tenant) (int, error) {
+	sites := Sites(tenants)
+	if len(sites) == 0 {
+		return 0, fmt.Errorf("no site found")
+	}
+
+	repaired := 0
+	ids := make(map[string]bool)
+	// uniqueId returns the id, or a new ID if the id is empty or duplicated
+	uniqueId := func(kind, id string) string {
+		if id == "" || ids[kind+"/"+id] {
+			id = g.f.UUID()
+			repaired++
+		}
+		ids[kind+"/"+id] = true
+		return id
+	}
+	// siteId returns the site.Id and counts a repair if the entity belongs to another site
+	siteId := func(site *model.Site, id string) string {
+		if id != site.Id {
+			repaired++
+		}
+		return site.Id
+	}
+
+	for _, site := range sites {
+		site.Id = uniqueId("site", site.Id)
+		if site.Region != nil && site.TenantId != site.Region.TenantId {
+			site.TenantId = site.Region.TenantId
+			repaired++
+		}
+
+		// Fleets
+		for _, f := range site.Fleets {
+			f.Id, f.SiteId = uniqueId("fleet", f.Id), siteId(site, f.SiteId)
+		}
+		if len(site.Fleets) == 0 {
+			for i := 0; i < max(g.cfg.FleetsPerSite, 1); i++ {
+				site.Fleets = append(site.Fleets, g.newFleet(site.Id))
+				repaired++
+			}
+		}
+		fleets := make(map[string]bool, len(site.Fleets))
+		for _, f := range site.Fleets {
+			fleets[f.Id] = true
+		}
+		randomFleetId := func() string {
+			repaired++
+			return site.Fleets[g.f.IntRange(0, len(site.Fleets)-1)].Id
+		}
+
+		// Channels
+		frequencies := g.NewFrequencyAllocator()
+		for _, c := range site.Channels {
+			frequencies.Reserve(c.TxFrequency)
+		}
+		txFrequencies := make(map[float64]bool, len(site.Channels))
+		rxFrequencies := make(map[float64]bool, len(site.Channels))
+		for _, c := range site.Channels {
+			c.Id, c.SiteId = uniqueId("channel", c.Id), siteId(site, c.SiteId)
+			if txFrequencies[c.TxFrequency] || rxFrequencies[c.RxFrequency] {
+				var err error
+				if c.TxFrequency, c.RxFrequency, err = frequencies.Allocate(); err != nil {
+					return repaired, fmt.Errorf("failed to allocate channel frequencies of site %q: %w", site.Id, err)
+				}
+				repaired++
+			}
+			txFrequencies[c.TxFrequency], rxFrequencies[c.RxFrequency] = true, true
+		}
+		if len(site.Channels) == 0 {
+			for i := 0; i < max(g.cfg.ChannelsPerSite, 1); i++ {
+				channel, err := g.newChannel(site.Id, frequencies)
+				if err != nil {
+					return repaired, fmt.Errorf("failed to allocate channel frequencies of site %q: %w", site.Id, err)
+				}
+				site.Channels = append(site.Channels, channel)
+				repaired++
+			}
+		}
+
+		// Talk groups
+		for _, tg := range site.TalkGroups {
+			tg.Id, tg.SiteId = uniqueId("talk group", tg.Id), siteId(site, tg.SiteId)
+			if !fleets[tg.FleetId] {
+				tg.FleetId = randomFleetId()
+			}
+		}
+		if len(site.TalkGroups) == 0 {
+			for i := 0; i < max(g.cfg.TalkGroupsPerSite, 1); i++ {
+				site.TalkGroups = append(site.TalkGroups, g.newTalkGroup(site.Id, randomFleetId()))
+			}
+		}
+		talkGroups := make(map[string]bool, len(site.TalkGroups))
+		for _, tg := range site.TalkGroups {
+			talkGroups[tg.Id] = true
+		}
+
+		// Units
+		for _, u := range site.Units {
+			u.Id, u.SiteId = uniqueId("unit", u.Id), siteId(site, u.SiteId)
+			if !talkGroups[u.TalkGroupId] {
+				u.TalkGroupId = site.TalkGroups[g.f.IntRange(0, len(site.TalkGroups)-1)].Id
+				repaired++
+			}
+		}
+		if len(site.Units) == 0 {
+			for _, tg := range site.TalkGroups {
+				for i := 0; i < max(g.cfg.UnitsPerTalkGroup, 1); i++ {
+					site.Units = append(site.Units, g.newUnit(site.Id, tg.Id))
+					repaired++
+				}
+			}
+		}
+
+		// Consoles
+		for _, c := range site.Consoles {
+			c.Id, c.SiteId = uniqueId("console", c.Id), siteId(site, c.SiteId)
+			if !fleets[c.FleetId] {
+				c.FleetId = randomFleetId()
+			}
+		}
+	}
+	return repaired, Validate(tenants)
+}
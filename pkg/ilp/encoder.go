@@ -0,0 +1,311 @@
+// Package ilp encodes rows in the InfluxDB Line Protocol (ILP) accepted by QuestDB.
+//
+// The Encoder has the same row building API as the LineSender of the QuestDB client, but writes to any io.Writer
+// (e.g. a file) and doesn't allocate memory per row, so it's used to write large ILP files quickly.
+package ilp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lnquy/quest-ei/pkg/hack"
+)
+
+// ErrInvalidMsg is returned for rows which can't be encoded, e.g. with illegal chars in table or column names.
+var ErrInvalidMsg = errors.New("invalid message")
+
+const nameLimit = 127 // Same limit as QuestDB's default cairo.max.file.name.length
+
+// Encoder buffers the encoded ILP rows and writes them to w when the buffer is full or flushed.
+// Rows are appended to the same buffer and numbers are formatted in place, so encoding a row never allocates.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	w          io.Writer
+	buf        []byte
+	bufCap     int
+	manual     bool // Rows are only written by Flush
+	lastMsgPos int  // End of the last finalized row, a row failing to encode is truncated from here
+	lastErr    error
+	hasTable   bool
+	hasTags    bool
+	hasFields  bool
+	tables     map[string]bool // Table and column names already validated
+	columns    map[string]bool
+}
+
+// NewEncoder returns an Encoder writing to w, which buffers up to bufCap bytes of rows before writing them.
+func NewEncoder(w io.Writer, bufCap int) *Encoder {
+	return &Encoder{
+		w:       w,
+		buf:     make([]byte, 0, bufCap+4096), // Room for the last row exceeding the capacity
+		bufCap:  bufCap,
+		tables:  make(map[string]bool, 16),
+		columns: make(map[string]bool, 64),
+	}
+}
+
+// DisableAutoFlush keeps the rows in the buffer until Flush is called, the buffer grows past its capacity instead
+// of being written when it's full. It's used when rows must only be written at known points, e.g. checkpoints.
+func (e *Encoder) DisableAutoFlush() {
+	e.manual = true
+}
+
+// Table starts a new row of the name table.
+func (e *Encoder) Table(name string) *Encoder {
+	if e.lastErr != nil {
+		return e
+	}
+	if e.hasTable {
+		e.lastErr = fmt.Errorf("table name already provided: %w", ErrInvalidMsg)
+		return e
+	}
+	if e.lastErr = e.writeName(name, true); e.lastErr != nil {
+		return e
+	}
+	e.hasTable = true
+	return e
+}
+
+// Symbol adds a symbol column to the row, symbols must be added before any other column.
+func (e *Encoder) Symbol(name, val string) *Encoder {
+	if e.lastErr != nil {
+		return e
+	}
+	if !e.hasTable {
+		e.lastErr = fmt.Errorf("table name was not provided: %w", ErrInvalidMsg)
+		return e
+	}
+	if e.hasFields {
+		e.lastErr = fmt.Errorf("symbols have to be written before any other column: %w", ErrInvalidMsg)
+		return e
+	}
+	e.buf = append(e.buf, ',')
+	if e.lastErr = e.writeName(name, false); e.lastErr != nil {
+		return e
+	}
+	e.buf = append(e.buf, '=')
+	e.writeValue(val, false)
+	e.hasTags = true
+	return e
+}
+
+// Int64Column adds a long column to the row.
+func (e *Encoder) Int64Column(name string, val int64) *Encoder {
+	if !e.prepareForField(name) {
+		return e
+	}
+	e.buf = strconv.AppendInt(e.buf, val, 10)
+	e.buf = append(e.buf, 'i')
+	return e
+}
+
+// TimestampColumn adds a timestamp column to the row, ts must not be negative.
+func (e *Encoder) TimestampColumn(name string, ts int64) *Encoder {
+	if ts < 0 {
+		if e.lastErr == nil {
+			e.lastErr = fmt.Errorf("timestamp cannot be negative: %d: %w", ts, ErrInvalidMsg)
+		}
+		return e
+	}
+	if !e.prepareForField(name) {
+		return e
+	}
+	e.buf = strconv.AppendInt(e.buf, ts, 10)
+	e.buf = append(e.buf, 't')
+	return e
+}
+
+// Float64Column adds a double column to the row.
+func (e *Encoder) Float64Column(name string, val float64) *Encoder {
+	if !e.prepareForField(name) {
+		return e
+	}
+	switch {
+	case math.IsNaN(val):
+		e.buf = append(e.buf, "NaN"...)
+	case math.IsInf(val, -1):
+		e.buf = append(e.buf, "-Infinity"...)
+	case math.IsInf(val, 1):
+		e.buf = append(e.buf, "Infinity"...)
+	default:
+		e.buf = strconv.AppendFloat(e.buf, val, 'G', -1, 64)
+	}
+	return e
+}
+
+// StringColumn adds a string column to the row.
+func (e *Encoder) StringColumn(name, val string) *Encoder {
+	if !e.prepareForField(name) {
+		return e
+	}
+	e.buf = append(e.buf, '"')
+	e.writeValue(val, true)
+	e.buf = append(e.buf, '"')
+	return e
+}
+
+// BoolColumn adds a boolean column to the row.
+func (e *Encoder) BoolColumn(name string, val bool) *Encoder {
+	if !e.prepareForField(name) {
+		return e
+	}
+	if val {
+		e.buf = append(e.buf, 't')
+	} else {
+		e.buf = append(e.buf, 'f')
+	}
+	return e
+}
+
+// At finalizes the row with the ts timestamp in Epoch nanoseconds.
+// The row is discarded if any of its columns failed to encode.
+// Buffered rows are written when the buffer is full, unless auto flush is disabled.
+func (e *Encoder) At(ts int64) error {
+	err := e.lastErr
+	e.lastErr = nil
+	if err == nil && !e.hasTable {
+		err = fmt.Errorf("table name was not provided: %w", ErrInvalidMsg)
+	}
+	if err == nil && !e.hasTags && !e.hasFields {
+		err = fmt.Errorf("no symbols or columns were provided: %w", ErrInvalidMsg)
+	}
+	if err != nil {
+		e.buf = e.buf[:e.lastMsgPos]
+		e.hasTable, e.hasTags, e.hasFields = false, false, false
+		return err
+	}
+
+	e.buf = append(e.buf, ' ')
+	e.buf = strconv.AppendInt(e.buf, ts, 10)
+	e.buf = append(e.buf, '\n')
+	e.lastMsgPos = len(e.buf)
+	e.hasTable, e.hasTags, e.hasFields = false, false, false
+	if !e.manual && len(e.buf) > e.bufCap {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush writes all finalized rows to the writer.
+func (e *Encoder) Flush() error {
+	if e.lastMsgPos == 0 {
+		return nil
+	}
+	n, err := e.w.Write(e.buf[:e.lastMsgPos])
+	// Keep the unwritten rows and the row being built
+	e.buf = e.buf[:copy(e.buf, e.buf[n:])]
+	e.lastMsgPos -= n
+	return err
+}
+
+// Messages returns the buffered rows which are not written yet.
+// The returned string shares the memory of the buffer, so it's only valid until the next call to the Encoder.
+func (e *Encoder) Messages() string {
+	return hack.BytesToString(e.buf[:e.lastMsgPos])
+}
+
+// Reset discards the buffered rows and writes the next rows to w.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.buf = e.buf[:0]
+	e.lastMsgPos = 0
+	e.lastErr = nil
+	e.hasTable, e.hasTags, e.hasFields = false, false, false
+}
+
+func (e *Encoder) prepareForField(name string) bool {
+	if e.lastErr != nil {
+		return false
+	}
+	if !e.hasTable {
+		e.lastErr = fmt.Errorf("table name was not provided: %w", ErrInvalidMsg)
+		return false
+	}
+	if !e.hasFields {
+		e.buf = append(e.buf, ' ')
+	} else {
+		e.buf = append(e.buf, ',')
+	}
+	if e.lastErr = e.writeName(name, false); e.lastErr != nil {
+		return false
+	}
+	e.buf = append(e.buf, '=')
+	e.hasFields = true
+	return true
+}
+
+const (
+	illegalTableNameChars  = "\n\r?,'\"\\/:)(+*%~"
+	illegalColumnNameChars = "\n\r?.,'\"\\/:)(+-*%~"
+)
+
+// writeName writes a table or column name, escaping spaces and equal signs.
+// Names are validated once, as the same few names are used by all rows.
+func (e *Encoder) writeName(name string, table bool) error {
+	validated := e.columns
+	if table {
+		validated = e.tables
+	}
+	if !validated[name] {
+		if err := validateName(name, table); err != nil {
+			return err
+		}
+		validated[name] = true
+	}
+	if strings.IndexAny(name, " =") < 0 {
+		e.buf = append(e.buf, name...)
+		return nil
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == ' ' || name[i] == '=' {
+			e.buf = append(e.buf, '\\')
+		}
+		e.buf = append(e.buf, name[i])
+	}
+	return nil
+}
+
+func validateName(name string, table bool) error {
+	if name == "" {
+		return fmt.Errorf("name cannot be empty: %w", ErrInvalidMsg)
+	}
+	if len(name) > nameLimit {
+		return fmt.Errorf("name length exceeds the limit: %s: %w", name, ErrInvalidMsg)
+	}
+	illegalChars := illegalColumnNameChars
+	if table {
+		illegalChars = illegalTableNameChars
+		if name[0] == '.' || name[len(name)-1] == '.' {
+			return fmt.Errorf("table name contains '.' char at the start or end: %s: %w", name, ErrInvalidMsg)
+		}
+	}
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if strings.IndexByte(illegalChars, b) >= 0 || (b < 0x10 && b != '\n' && b != '\r') || b == 0x7f {
+			return fmt.Errorf("name contains an illegal char %q: %s: %w", b, name, ErrInvalidMsg)
+		}
+	}
+	return nil
+}
+
+// writeValue writes a symbol value or a (quoted) string value, escaping the special chars.
+func (e *Encoder) writeValue(val string, quoted bool) {
+	specialChars := " ,=\n\r\\"
+	if quoted {
+		specialChars = "\"\n\r\\"
+	}
+	if strings.IndexAny(val, specialChars) < 0 { // Fast path, most values don't need escaping
+		e.buf = append(e.buf, val...)
+		return
+	}
+	for i := 0; i < len(val); i++ {
+		if strings.IndexByte(specialChars, val[i]) >= 0 {
+			e.buf = append(e.buf, '\\')
+		}
+		e.buf = append(e.buf, val[i])
+	}
+}
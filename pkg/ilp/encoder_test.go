@@ -0,0 +1,359 @@
+package ilp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/model"
+	qdb "github.com/questdb/go-questdb-client"
+)
+
+var testCall = model.Call{
+	Id:                     "fedcba98-7654-3210-fedc-ba9876543210",
+	RegionId:               "01234567-89ab-cdef-0123-456789abcdef",
+	SiteId:                 "6f1c9a0e-5d3b-4c8e-9a7f-1b2c3d4e5f60",
+	ChannelId:              "0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9",
+	FleetId:                "11111111-2222-3333-4444-555555555555",
+	SourceUnitId:           "99999999-8888-7777-6666-555555555555",
+	SourceUnitHomeSiteId:   "6f1c9a0e-5d3b-4c8e-9a7f-1b2c3d4e5f60",
+	DestinationTalkGroupId: "abcdefab-cdef-abcd-efab-cdefabcdefab",
+	StartedAt:              time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndedAt:                time.Date(2022, 1, 1, 0, 3, 21, 0, time.UTC),
+	DurationSecond:         201,
+	RssiDbm:                -87.25,
+	SnrDb:                  18.5,
+	BerPercent:             0.013,
+	AudioQuality:           4.2,
+}
+
+// encodeCall encodes the call like the calls table is written.
+func encodeCall(e *Encoder, c *model.Call) error {
+	return e.Table("calls").
+		Symbol("region_id", c.RegionId).
+		Symbol("site_id", c.SiteId).
+		Symbol("channel_id", c.ChannelId).
+		Symbol("fleet_id", c.FleetId).
+		Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
+		Symbol("source_unit_id", c.SourceUnitId).
+		Symbol("source_unit_home_site_id", c.SourceUnitHomeSiteId).
+		StringColumn("id", c.Id).
+		TimestampColumn("started_at", c.StartedAt.UnixNano()).
+		TimestampColumn("ended_at", c.EndedAt.UnixNano()).
+		Int64Column("duration_sec", c.DurationSecond).
+		Float64Column("rssi_dbm", c.RssiDbm).
+		Float64Column("snr_db", c.SnrDb).
+		Float64Column("ber_pct", c.BerPercent).
+		Float64Column("audio_quality", c.AudioQuality).
+		At(c.StartedAt.UnixNano())
+}
+
+// senderEncodeCall encodes the same call with the qdb.LineSender, which rows were built with before the Encoder.
+func senderEncodeCall(ctx context.Context, s *qdb.LineSender, c *model.Call) error {
+	return s.Table("calls").
+		Symbol("region_id", c.RegionId).
+		Symbol("site_id", c.SiteId).
+		Symbol("channel_id", c.ChannelId).
+		Symbol("fleet_id", c.FleetId).
+		Symbol("destination_talk_group_id", c.DestinationTalkGroupId).
+		Symbol("source_unit_id", c.SourceUnitId).
+		Symbol("source_unit_home_site_id", c.SourceUnitHomeSiteId).
+		StringColumn("id", c.Id).
+		TimestampColumn("started_at", c.StartedAt.UnixNano()).
+		TimestampColumn("ended_at", c.EndedAt.UnixNano()).
+		Int64Column("duration_sec", c.DurationSecond).
+		Float64Column("rssi_dbm", c.RssiDbm).
+		Float64Column("snr_db", c.SnrDb).
+		Float64Column("ber_pct", c.BerPercent).
+		Float64Column("audio_quality", c.AudioQuality).
+		At(ctx, c.StartedAt.UnixNano())
+}
+
+// discardServer accepts connections and discards what they send, as the qdb.LineSender always connects to QuestDB,
+// even when its Messages() were written to a file.
+func discardServer(tb testing.TB) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestEncoderMatchesLineSender(t *testing.T) {
+	ctx := context.Background()
+	s, err := qdb.NewLineSender(ctx, qdb.WithAddress(discardServer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var got bytes.Buffer
+	e := NewEncoder(&got, 1024*1024)
+	calls := []model.Call{testCall, testCall, testCall}
+	calls[1].SiteId, calls[1].Id = "site with, = escapes", "id with \"quotes\""
+	calls[2].RssiDbm, calls[2].SnrDb, calls[2].BerPercent = math.NaN(), math.Inf(1), 1e21
+	for i := range calls {
+		if err := encodeCall(e, &calls[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := senderEncodeCall(ctx, s, &calls[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != s.Messages() {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), s.Messages())
+	}
+}
+
+func TestEncoderEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(e *Encoder) *Encoder
+		want  string
+	}{
+		{
+			name:  "symbol",
+			build: func(e *Encoder) *Encoder { return e.Table("t").Symbol("s", "a b,c=d\\e\nf\rg") },
+			want:  `t,s=a\ b\,c\=d\\e\` + "\n" + `f\` + "\r" + "g 1\n",
+		},
+		{
+			name:  "string",
+			build: func(e *Encoder) *Encoder { return e.Table("t").StringColumn("s", `a "b" c\d,e=f`+"\n") },
+			want:  `t s="a \"b\" c\\d,e=f\` + "\n\" 1\n",
+		},
+		{
+			name: "names",
+			build: func(e *Encoder) *Encoder {
+				return e.Table("my table=1").Symbol("my tag", "v").Int64Column("my=field", 1)
+			},
+			want: `my\ table\=1,my\ tag=v my\=field=1i 1` + "\n",
+		},
+		{
+			name:  "bool",
+			build: func(e *Encoder) *Encoder { return e.Table("t").BoolColumn("a", true).BoolColumn("b", false) },
+			want:  "t a=t,b=f 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf, 1024)
+			if err := tt.build(e).At(1); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderFloats(t *testing.T) {
+	tests := []struct {
+		val  float64
+		want string
+	}{
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "Infinity"},
+		{math.Inf(-1), "-Infinity"},
+		{-87.25, "-87.25"},
+		{1e21, "1E+21"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, 1024)
+		if err := e.Table("t").Float64Column("f", tt.val).At(1); err != nil {
+			t.Fatal(err)
+		}
+		_ = e.Flush()
+		if want := "t f=" + tt.want + " 1\n"; buf.String() != want {
+			t.Errorf("%v: got %q, want %q", tt.val, buf.String(), want)
+		}
+	}
+}
+
+func TestEncoderInvalidRows(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(e *Encoder) *Encoder
+	}{
+		{"empty table", func(e *Encoder) *Encoder { return e.Table("").Int64Column("a", 1) }},
+		{"table starting with dot", func(e *Encoder) *Encoder { return e.Table(".t").Int64Column("a", 1) }},
+		{"illegal table char", func(e *Encoder) *Encoder { return e.Table("a/b").Int64Column("a", 1) }},
+		{"illegal column char", func(e *Encoder) *Encoder { return e.Table("t").Int64Column("a.b", 1) }},
+		{"too long name", func(e *Encoder) *Encoder { return e.Table("t").Int64Column(strings.Repeat("a", nameLimit+1), 1) }},
+		{"no table", func(e *Encoder) *Encoder { return e.Int64Column("a", 1) }},
+		{"table twice", func(e *Encoder) *Encoder { return e.Table("t").Table("t").Int64Column("a", 1) }},
+		{"symbol after column", func(e *Encoder) *Encoder { return e.Table("t").Int64Column("a", 1).Symbol("s", "v") }},
+		{"no column", func(e *Encoder) *Encoder { return e.Table("t") }},
+		{"negative timestamp", func(e *Encoder) *Encoder { return e.Table("t").TimestampColumn("ts", -1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf, 1024)
+			if err := tt.build(e).At(1); !errors.Is(err, ErrInvalidMsg) {
+				t.Fatalf("got error %v, want ErrInvalidMsg", err)
+			}
+		})
+	}
+}
+
+func TestEncoderDiscardsInvalidRows(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, 1024)
+	if err := e.Table("t").Int64Column("a", 1).At(1); err != nil {
+		t.Fatal(err)
+	}
+	// Failing in the middle of a row, after some columns were already encoded
+	if err := e.Table("t").Int64Column("a", 2).Int64Column("b,c", 2).StringColumn("s", "x").At(2); err == nil {
+		t.Fatal("got no error for an invalid column name")
+	}
+	if err := e.Table("t").TimestampColumn("ts", -1).At(3); err == nil {
+		t.Fatal("got no error for a negative timestamp")
+	}
+	if err := e.Table("t").Int64Column("a", 4).At(4); err != nil {
+		t.Fatal(err)
+	}
+	if want := "t a=1i 1\nt a=4i 4\n"; e.Messages() != want {
+		t.Errorf("got buffered %q, want %q", e.Messages(), want)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "t a=1i 1\nt a=4i 4\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestEncoderFlushesWhenFull(t *testing.T) {
+	var buf bytes.Buffer
+	row := "t a=1i 1\n"
+	e := NewEncoder(&buf, 2*len(row))
+	for i := 0; i < 3; i++ {
+		if err := e.Table("t").Int64Column("a", 1).At(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != strings.Repeat(row, 3) || e.Messages() != "" {
+		t.Errorf("got written %q and buffered %q, want all rows written once the buffer is full", buf.String(), e.Messages())
+	}
+}
+
+func TestEncoderDisableAutoFlush(t *testing.T) {
+	var buf bytes.Buffer
+	row := "t a=1i 1\n"
+	e := NewEncoder(&buf, len(row))
+	e.DisableAutoFlush()
+	for i := 0; i < 3; i++ {
+		if err := e.Table("t").Int64Column("a", 1).At(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 0 || e.Messages() != strings.Repeat(row, 3) {
+		t.Fatalf("got written %q and buffered %q, want all rows buffered", buf.String(), e.Messages())
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != strings.Repeat(row, 3) {
+		t.Errorf("got %q, want all rows written by Flush", buf.String())
+	}
+}
+
+// Settings of the benchmarks, the default --flush-batch-size and --flush-batch-buffer-mb.
+const (
+	benchBatchSize = 10000
+	benchBufferMB  = 100
+)
+
+// BenchmarkEncoder measures the current path of the calls: the call reused by the generator is encoded
+// into the Encoder, which is flushed every batch. It shouldn't allocate per row.
+func BenchmarkEncoder(b *testing.B) {
+	e := NewEncoder(io.Discard, benchBufferMB*1024*1024)
+	call := testCall
+	b.ReportAllocs()
+	b.ResetTimer()
+	started := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := encodeCall(e, &call); err != nil {
+			b.Fatal(err)
+		}
+		if (i+1)%benchBatchSize == 0 {
+			if err := e.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := e.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(started).Seconds(), "rows/s")
+}
+
+// BenchmarkLineSender measures the path replaced by the Encoder, for comparison with BenchmarkEncoder:
+// a *model.Call is allocated per row and the batch is encoded by a qdb.LineSender, then its Messages() are written
+// and the sender is closed and created again to empty its buffer, at every flush.
+func BenchmarkLineSender(b *testing.B) {
+	ctx := context.Background()
+	addr := discardServer(b)
+	newSender := func() *qdb.LineSender {
+		s, err := qdb.NewLineSender(ctx, qdb.WithAddress(addr), qdb.WithBufferCapacity(benchBufferMB*1024*1024))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return s
+	}
+	s := newSender()
+	calls := make([]*model.Call, 0, benchBatchSize)
+	flush := func() {
+		for _, c := range calls {
+			if err := senderEncodeCall(ctx, s, c); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := io.WriteString(io.Discard, s.Messages()); err != nil {
+			b.Fatal(err)
+		}
+		_ = s.Close()
+		s = newSender()
+		calls = make([]*model.Call, 0, benchBatchSize)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	started := time.Now()
+	for i := 0; i < b.N; i++ {
+		call := testCall
+		calls = append(calls, &call)
+		if len(calls) == benchBatchSize {
+			flush()
+		}
+	}
+	flush()
+	b.ReportMetric(float64(b.N)/time.Since(started).Seconds(), "rows/s")
+	_ = s.Close()
+}
@@ -6,18 +6,67 @@ const (
 	StatusActive Status = 1
 )
 
+const (
+	MessageTypeStatus   = "status"   // Predefined status code, e.g. "en route", "on scene"
+	MessageTypeText     = "sds_text" // Short Data Service text message
+	MessageTypeLocation = "location" // GPS location report
+
+	MessageDelivered = "delivered"
+	MessageFailed    = "failed"
+	MessageExpired   = "expired"
+)
+
+const (
+	AlarmSeverityWarning  = "warning"
+	AlarmSeverityMajor    = "major"
+	AlarmSeverityCritical = "critical"
+)
+
 type Status = int64
 
-type Site struct {
+type Tenant struct {
 	Id     string `json:"id"`
 	Name   string `json:"name"`
+	Slug   string `json:"slug"` // Lower case name, used as the tenant's table suffix or output file suffix
 	Status Status `json:"status"`
 
+	Regions []*Region `json:"regions,omitempty"`
+}
+
+type Region struct {
+	Id       string `json:"id"`
+	TenantId string `json:"tenantId,omitempty"`
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+
+	// Load profile of the region's sites, MaxLoad = 0 means using the global load factors instead
+	MinLoad             float64 `json:"minLoad,omitempty"`
+	MaxLoad             float64 `json:"maxLoad,omitempty"`
+	TimezoneOffsetHours int     `json:"timezoneOffsetHours,omitempty"` // Shifts the daily low load duration to the region's local time
+
+	Sites []*Site `json:"sites,omitempty"`
+}
+
+type Site struct {
+	Id       string `json:"id"`
+	TenantId string `json:"tenantId,omitempty"`
+	RegionId string `json:"regionId,omitempty"`
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Poor     bool   `json:"poor,omitempty"` // Poor sites have less entities and degraded radio signal quality
+	// QualityTier is the name of the quality tier the site was generated from, empty for imported sites
+	QualityTier string `json:"qualityTier,omitempty"`
+
 	// Internal uses
 	Channels   []*Channel   `json:"channels,omitempty"`
 	Fleets     []*Fleet     `json:"fleets,omitempty"`
 	TalkGroups []*TalkGroup `json:"talkGroups,omitempty"`
 	Units      []*Unit      `json:"units,omitempty"`
+	Consoles   []*Console   `json:"consoles,omitempty"`
+
+	// Runtime only
+	Region          *Region `json:"-"`
+	RegisteredUnits []*Unit `json:"-"` // Units currently registered to this site (including roaming units from other sites)
 }
 
 // type SiteReading struct {
@@ -30,8 +79,8 @@ type Channel struct {
 	Id          string  `json:"id"`
 	SiteId      string  `json:"siteId"`
 	Name        string  `json:"name"`
-	TxFrequency float64 `json:"txFrequency"`
-	RxFrequency float64 `json:"rxFrequency"`
+	TxFrequency float64 `json:"txFrequency"` // MHz
+	RxFrequency float64 `json:"rxFrequency"` // MHz
 	Status      Status  `json:"status"`
 }
 
@@ -56,16 +105,117 @@ type Unit struct {
 	TalkGroupId string `json:"talkGroupId"` // 1 unit can be in multiple talkgroup?
 	Name        string `json:"name"`
 	Status      Status `json:"status"`
+	// MessagesPerHour is the average number of short data/status messages sent by the unit per hour
+	MessagesPerHour float64 `json:"messagesPerHour,omitempty"`
+
+	// CurrentSiteId is the site the unit is currently registered to.
+	// Empty or equal to SiteId means the unit is at its home site.
+	CurrentSiteId string `json:"currentSiteId,omitempty"`
+}
+
+type Console struct {
+	Id      string `json:"id"`
+	SiteId  string `json:"siteId"`
+	FleetId string `json:"fleetId"` // Dispatchers of a console serve the talk groups of a fleet
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+}
+
+type ConsoleSession struct {
+	Id        string    `json:"id"`
+	ConsoleId string    `json:"consoleId"`
+	SiteId    string    `json:"siteId"`
+	FleetId   string    `json:"fleetId"`
+	Operator  string    `json:"operator"`
+	LoginAt   time.Time `json:"loginAt"`
+	LogoutAt  time.Time `json:"logoutAt"` // Zero while the operator is logged in
+}
+
+type TalkGroupPatch struct {
+	Id                 string    `json:"id"`
+	SiteId             string    `json:"siteId"`
+	ConsoleId          string    `json:"consoleId"`
+	TalkGroupId        string    `json:"talkGroupId"`
+	PatchedTalkGroupId string    `json:"patchedTalkGroupId"`
+	PatchedAt          time.Time `json:"patchedAt"`
+	UnpatchedAt        time.Time `json:"unpatchedAt"` // Zero while the talk groups are patched
+}
+
+type UnitRegistration struct {
+	UnitId         string    `json:"unitId"`
+	SiteId         string    `json:"siteId"`
+	PreviousSiteId string    `json:"previousSiteId"`
+	HomeSiteId     string    `json:"homeSiteId"`
+	RegisteredAt   time.Time `json:"registeredAt"`
 }
 
 type Call struct {
 	Id                     string    `json:"id"`
+	TenantId               string    `json:"tenantId"`
+	RegionId               string    `json:"regionId"`
 	SiteId                 string    `json:"siteId"`
 	ChannelId              string    `json:"channelId"`
 	FleetId                string    `json:"fleetId"` // FleetId of the sourceTalkGroup/Unit?
-	SourceUnitId           string    `json:"sourceUnitId"`
+	SourceUnitId           string    `json:"sourceUnitId,omitempty"`
+	SourceUnitHomeSiteId   string    `json:"sourceUnitHomeSiteId,omitempty"` // Differs from SiteId when the unit is roaming
+	SourceConsoleId        string    `json:"sourceConsoleId,omitempty"`      // Set instead of SourceUnitId for console originated calls
 	DestinationTalkGroupId string    `json:"destinationTalkGroupId"`
 	StartedAt              time.Time `json:"startedAt"`
 	EndedAt                time.Time `json:"endedAt"` // Should track this or each call into 2 separated events
 	DurationSecond         int64     `json:"durationSecond"`
+
+	// Radio signal quality
+	RssiDbm      float64 `json:"rssiDbm"`
+	SnrDb        float64 `json:"snrDb"`
+	BerPercent   float64 `json:"berPercent"`
+	AudioQuality float64 `json:"audioQuality"` // Mean Opinion Score [1, 5]
+}
+
+type ChannelReading struct {
+	ChannelId          string    `json:"channelId"`
+	SiteId             string    `json:"siteId"`
+	NoiseFloorDbm      float64   `json:"noiseFloorDbm"`
+	InterferenceDbm    float64   `json:"interferenceDbm"`
+	UtilisationPercent float64   `json:"utilisationPercent"`
+	Timestamp          time.Time `json:"timestamp"`
+
+	// Internal uses
+	Calls int `json:"-"` // Number of calls started on the channel in the reading interval
+}
+
+type SiteEquipmentReading struct {
+	SiteId            string    `json:"siteId"`
+	State             string    `json:"state"` // normal, degrading or outage
+	PaTemperatureC    float64   `json:"paTemperatureC"`
+	ForwardPowerW     float64   `json:"forwardPowerW"`
+	ReflectedPowerW   float64   `json:"reflectedPowerW"`
+	Vswr              float64   `json:"vswr"`
+	MainsPower        bool      `json:"mainsPower"`
+	BatteryPercent    float64   `json:"batteryPercent"`
+	BackhaulLatencyMs float64   `json:"backhaulLatencyMs"` // NaN when the backhaul link is down
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+type Alarm struct {
+	Id         string    `json:"id"`
+	SiteId     string    `json:"siteId"`
+	Code       string    `json:"code"`
+	Severity   string    `json:"severity"`
+	SourceType string    `json:"sourceType"` // site, pa, antenna, power_supply or backhaul
+	SourceId   string    `json:"sourceId"`
+	RaisedAt   time.Time `json:"raisedAt"`
+	ClearedAt  time.Time `json:"clearedAt"` // Zero while the alarm is active
+}
+
+type Message struct {
+	Id                     string    `json:"id"`
+	SiteId                 string    `json:"siteId"`
+	SourceUnitId           string    `json:"sourceUnitId"`
+	DestinationUnitId      string    `json:"destinationUnitId,omitempty"`      // Set for unit to unit messages
+	DestinationTalkGroupId string    `json:"destinationTalkGroupId,omitempty"` // Set for group messages
+	Type                   string    `json:"type"`
+	PayloadBytes           int64     `json:"payloadBytes"`
+	DeliveryStatus         string    `json:"deliveryStatus"`
+	LatencyMs              int64     `json:"latencyMs"`
+	SentAt                 time.Time `json:"sentAt"`
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/lnquy/quest-ei/pkg/generator"
+)
+
+// getQualityTiers returns the quality tiers from the "quality-tiers-file" if provided,
+// otherwise the default tiers with "poor-site-rate" poor sites.
+func getQualityTiers() ([]generator.QualityTier, error) {
+	if fQualityTiersFile == "" {
+		if fPoorSiteRate < 0 || fPoorSiteRate > 1 {
+			return nil, fmt.Errorf("poor site rate must be in [0, 1], got %f", fPoorSiteRate)
+		}
+		return generator.DefaultQualityTiers(fPoorSiteRate), nil
+	}
+
+	b, err := ioutil.ReadFile(fQualityTiersFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quality tiers file: %w", err)
+	}
+	var tiers []generator.QualityTier
+	if err := json.Unmarshal(b, &tiers); err != nil {
+		return nil, fmt.Errorf("failed to decode quality tiers file: %w", err)
+	}
+	return tiers, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dialTimeout         = 5 * time.Second
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// errBackoff is returned by reconnect while live generations wait for the backoff.
+var errBackoff = errors.New("waiting to reconnect")
+
+// questdbConn is the TCP connection of an output to QuestDB, written by the ILP encoder of the output.
+// When the connection breaks (e.g. QuestDB restarts), it reconnects with exponential backoff:
+//   - Historical generations wait until reconnected, so no row is lost. Writes fail with a connection error
+//     if QuestDB is still unreachable after "reconnect-timeout", or the generation is interrupted meanwhile.
+//   - Live generations keep generating, the rows written meanwhile are kept in a backlog of "reconnect-buffer-mb",
+//     then spilled to a file of the "spill-dir" if any, or else the oldest rows are dropped and reported as lost.
+//
+// The backlog is sent once reconnected, before the new rows. Rows written to a connection right before it breaks
+// might still be lost, as ILP over TCP has no acknowledgement.
+// A questdbConn is only used by the worker owning its output.
+type questdbConn struct {
+	ctx            context.Context
+	addr           string
+	live           bool
+	draining       bool          // Sending the backlog before closing, which isn't interrupted by the ctx
+	conn           net.Conn      // Nil while disconnected
+	closed         chan struct{} // Closed when QuestDB closes the conn
+	lastErr        error
+	failed         error // Set once reconnecting failed, all writes fail then
+	disconnectedAt time.Time
+	backoff        time.Duration
+	retryAt        time.Time
+	backlog        []byte
+	backlogCap     int
+	spill          *os.File // Overflow of the backlog, nil if not spilled
+	spilled        int64    // Bytes of the spill file which are not sent yet
+	lost           int      // Rows dropped since the last reconnection
+
+	// Sizes of the backlog and the spilled rows, stored atomically for the metrics
+	backlogBytes int64
+	spillBytes   int64
+}
+
+// newQuestdbConn connects to QuestDB, failing fast if QuestDB can't be reached at start.
+// Historical generations stop reconnecting when the ctx is done.
+func newQuestdbConn(ctx context.Context, addr string, live bool) (*questdbConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &questdbConn{ctx: ctx, addr: addr, live: live, backlogCap: fReconnectBufferMB * 1024 * 1024}
+	c.connected(conn)
+	return c, nil
+}
+
+// Write writes the ILP messages to QuestDB, or to the backlog while disconnected.
+// Live generations never fail, errors are logged and reported to the ingestion statistics instead.
+func (c *questdbConn) Write(p []byte) (int, error) {
+	defer c.updateGauges()
+	if c.failed != nil {
+		return 0, c.failed
+	}
+	select {
+	case <-c.closed: // Nil while disconnected
+		c.disconnect(errors.New("connection closed by QuestDB"))
+	default:
+	}
+	if c.conn == nil {
+		if err := c.reconnect(); err != nil {
+			if !c.live {
+				c.failed = err
+				return 0, err
+			}
+			c.buffer(p)
+			return len(p), nil
+		}
+	}
+	if n, err := c.send(p); err != nil {
+		c.disconnect(err)
+		c.buffer(c.unsent(p, n))
+		if !c.live { // Wait for the connection, rather than buffering all rows
+			if err := c.reconnect(); err != nil {
+				c.failed = err
+				return len(p), err // The rest of p is in the backlog, reported as lost when closing
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// send writes the ILP messages to the connection, recording the rows which reached it as flushed.
+func (c *questdbConn) send(p []byte) (int, error) {
+	started := time.Now()
+	n, err := c.conn.Write(p)
+	if n > 0 {
+		ingestion.sent(p[:n], time.Since(started))
+	}
+	return n, err
+}
+
+// unsent returns the rows of p which were not sent when the connection broke after writing n bytes.
+// The row sent partially is dropped and reported as lost, as sending its rest would make an invalid row.
+func (c *questdbConn) unsent(p []byte, n int) []byte {
+	if n == 0 || p[n-1] == '\n' {
+		return p[n:]
+	}
+	c.dropPartialRow()
+	if i := bytes.IndexByte(p[n:], '\n'); i >= 0 {
+		return p[n+i+1:]
+	}
+	return nil
+}
+
+func (c *questdbConn) dropPartialRow() {
+	c.lost++
+	ingestion.dropped(1)
+}
+
+// connected starts using the conn, and watches it in background to notice when QuestDB closes it
+// (e.g. when it's shutting down), so the rows are not written to a closed connection.
+// QuestDB never writes to ILP connections, so reading only returns once the connection is closed.
+func (c *questdbConn) connected(conn net.Conn) {
+	closed := make(chan struct{})
+	go func() {
+		var b [1]byte
+		_, _ = conn.Read(b[:])
+		close(closed)
+	}()
+	c.conn, c.closed = conn, closed
+}
+
+func (c *questdbConn) disconnect(err error) {
+	_ = c.conn.Close()
+	c.conn, c.closed = nil, nil
+	c.lastErr = err
+	c.disconnectedAt = time.Now()
+	c.backoff = minReconnectBackoff
+	c.retryAt = c.disconnectedAt.Add(c.backoff)
+	ingestion.disconnected(err)
+	log.Printf(" > Lost connection to QuestDB at %s, reconnecting in %s: %s", c.addr, c.backoff, err)
+}
+
+// reconnect connects to QuestDB again once the backoff is over, and sends the backlog. It returns nil once
+// the connection is up. Live generations don't wait for the backoff, while historical generations wait
+// until reconnected or it fails (see waitRetry).
+func (c *questdbConn) reconnect() error {
+	for {
+		if c.conn == nil {
+			if !c.live {
+				if err := c.waitRetry(); err != nil {
+					return err
+				}
+			} else if time.Now().Before(c.retryAt) {
+				return errBackoff
+			}
+			conn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+			if err != nil {
+				c.lastErr = err
+				c.backoff *= 2
+				if c.backoff > maxReconnectBackoff {
+					c.backoff = maxReconnectBackoff
+				}
+				c.retryAt = time.Now().Add(c.backoff)
+				log.Printf(" > Failed to reconnect to QuestDB at %s, retrying in %s: %s", c.addr, c.backoff, err)
+				if c.live {
+					return err
+				}
+				continue
+			}
+			c.connected(conn)
+			ingestion.reconnected()
+			log.Printf(" > Reconnected to QuestDB at %s after %s, %d rows lost", c.addr, time.Since(c.disconnectedAt), c.lost)
+			c.lost = 0
+		}
+		if err := c.sendBacklog(); err != nil {
+			c.disconnect(err)
+			if c.live {
+				return err
+			}
+			continue
+		}
+		return nil
+	}
+}
+
+// waitRetry waits until the backoff is over. It fails once disconnected for "reconnect-timeout",
+// or when the ctx is done, unless draining (which is bounded by the "shutdown-timeout" of main instead).
+func (c *questdbConn) waitRetry() error {
+	if time.Since(c.disconnectedAt) >= reconnectTimeout {
+		return connectionError(c.lastErr, fmt.Sprintf("failed to reconnect to QuestDB at %s within %s", c.addr, reconnectTimeout))
+	}
+	var done <-chan struct{}
+	if !c.draining {
+		done = c.ctx.Done()
+	}
+	timer := time.NewTimer(time.Until(c.retryAt))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return connectionError(c.lastErr, "interrupted while reconnecting to QuestDB at "+c.addr)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sendBacklog sends the backlog then its spilled overflow, the unsent part is kept if the connection breaks again.
+func (c *questdbConn) sendBacklog() error {
+	n, err := c.send(c.backlog)
+	c.backlog = c.backlog[:copy(c.backlog, c.unsent(c.backlog, n))]
+	if err != nil || c.spill == nil {
+		return err
+	}
+	offset, err := c.spill.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	sent, err := io.Copy(sendWriter{c}, c.spill) // Reads from the spill offset, which is moved by each send
+	c.spilled -= sent
+	if err != nil {
+		// io.Copy reads ahead of the sent bytes, so the next send starts from the first unsent row instead
+		if serr := c.skipSentSpill(offset + sent); serr != nil {
+			log.Printf(" > Failed to rewind spill file %s: %s", c.spill.Name(), serr)
+		}
+		return err
+	}
+	log.Printf(" > Sent %d bytes spilled to %s", sent, c.spill.Name())
+	_ = c.spill.Close()
+	_ = os.Remove(c.spill.Name())
+	c.spill = nil
+	c.spilled = 0
+	return nil
+}
+
+// skipSentSpill moves the read offset of the spill file to the sent offset, then past the row sent partially, if any.
+func (c *questdbConn) skipSentSpill(offset int64) error {
+	if offset > 0 {
+		var last [1]byte
+		if _, err := c.spill.ReadAt(last[:], offset-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			c.dropPartialRow()
+			buf := make([]byte, 4096)
+			for {
+				n, err := c.spill.ReadAt(buf, offset)
+				if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+					offset += int64(i + 1)
+					c.spilled -= int64(i + 1)
+					break
+				}
+				offset += int64(n)
+				c.spilled -= int64(n)
+				if err != nil { // Spilled rows always end with a newline, see spillRows
+					break
+				}
+			}
+		}
+	}
+	_, err := c.spill.Seek(offset, io.SeekStart)
+	return err
+}
+
+// sendWriter sends the writes to the connection, to copy the spill file.
+type sendWriter struct{ c *questdbConn }
+
+func (w sendWriter) Write(p []byte) (int, error) { return w.c.send(p) }
+
+// buffer appends the rows to the backlog, spilling them once the backlog is full, or dropping the oldest rows.
+func (c *questdbConn) buffer(p []byte) {
+	if !c.live { // Historical generations wait until reconnected, so the backlog is only the rows of the failed write
+		c.backlog = append(c.backlog, p...)
+		return
+	}
+	if len(p) == 0 {
+		return
+	}
+	if c.spill == nil && len(c.backlog)+len(p) > c.backlogCap && fSpillDir != "" {
+		f, err := ioutil.TempFile(fSpillDir, "quest-ei-spill-*.ilp")
+		if err != nil {
+			log.Printf(" > Failed to create spill file, dropping rows instead: %s", err)
+		} else {
+			c.spill = f
+			log.Printf(" > Backlog is full, spilling to: %s", f.Name())
+		}
+	}
+	if c.spill != nil {
+		err := c.spillRows(p)
+		if err == nil {
+			return
+		}
+		log.Printf(" > Failed to spill rows to %s, dropping rows instead: %s", c.spill.Name(), err)
+	}
+
+	c.backlog = append(c.backlog, p...)
+	if over := len(c.backlog) - c.backlogCap; over > 0 {
+		// Drop whole rows, up to the end of the row exceeding the capacity
+		cut := len(c.backlog)
+		if i := bytes.IndexByte(c.backlog[over-1:], '\n'); i >= 0 {
+			cut = over + i
+		}
+		lost := bytes.Count(c.backlog[:cut], []byte{'\n'})
+		c.backlog = c.backlog[:copy(c.backlog, c.backlog[cut:])]
+		c.lost += lost
+		ingestion.dropped(lost)
+	}
+}
+
+// spillRows appends the rows to the spill file, keeping the read offset of the file for sending the backlog.
+func (c *questdbConn) spillRows(p []byte) error {
+	offset, err := c.spill.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := c.spill.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := c.spill.WriteAt(p, end); err != nil {
+		_ = c.spill.Truncate(end) // Keep the file ending with a whole row
+		return err
+	}
+	c.spilled += int64(len(p))
+	_, err = c.spill.Seek(offset, io.SeekStart)
+	return err
+}
+
+// drain waits until reconnected to send the backlog, like historical generations do. It's bounded by the
+// "shutdown-timeout" of main.
+func (c *questdbConn) drain() {
+	if c.failed != nil || (len(c.backlog) == 0 && c.spill == nil) {
+		return
+	}
+	log.Printf(" > Sending the backlog to QuestDB at %s before closing", c.addr)
+	c.live, c.draining = false, true
+	if err := c.reconnect(); err != nil {
+		log.Printf(" > Failed to send the backlog: %s", err)
+	}
+	c.updateGauges()
+}
+
+// updateGauges publishes the sizes of the backlog and the spilled rows.
+func (c *questdbConn) updateGauges() {
+	atomic.StoreInt64(&c.backlogBytes, int64(len(c.backlog)))
+	atomic.StoreInt64(&c.spillBytes, c.spilled)
+}
+
+// Close closes the connection, the rows of the backlog which are still not sent are reported as lost.
+// Spilled rows are kept in their file, so they can be ingested later.
+func (c *questdbConn) Close() error {
+	if lost := bytes.Count(c.backlog, []byte{'\n'}); lost > 0 {
+		ingestion.dropped(lost)
+		log.Printf(" > %d rows of the backlog were never sent to QuestDB at %s", lost, c.addr)
+	}
+	c.backlog = nil
+	c.updateGauges()
+	if c.spill != nil {
+		log.Printf(" > Rows spilled to %s were never sent to QuestDB", c.spill.Name())
+		_ = c.spill.Close()
+	}
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
@@ -1,13 +1,37 @@
+-- With --tenant-routing=table, create these tables for every tenant with the "_<tenant slug>" suffix instead,
+-- e.g. calls_police.
+CREATE TABLE 'tenants' (
+                           tenant_id SYMBOL CAPACITY 100 CACHE,
+                           name SYMBOL CAPACITY 100 CACHE,
+                           slug SYMBOL CAPACITY 100 CACHE,
+                           status LONG,
+                           timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE tenants ALTER COLUMN tenant_id ADD INDEX;
+
+CREATE TABLE 'regions' (
+                           tenant_id SYMBOL CAPACITY 100 CACHE,
+                           id SYMBOL CAPACITY 100 CACHE,
+                           name SYMBOL CAPACITY 100 CACHE,
+                           status LONG,
+                           timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE regions ALTER COLUMN id ADD INDEX;
+
 CREATE TABLE 'sites' (
+                         tenant_id SYMBOL CAPACITY 100 CACHE,
                          id SYMBOL CAPACITY 100 CACHE, -- At most 28 sites
+                         region_id SYMBOL CAPACITY 100 CACHE,
                          name SYMBOL CAPACITY 100 CACHE,
                          status LONG,
                          timestamp TIMESTAMP
 ) timestamp (timestamp) PARTITION BY DAY;
 ALTER TABLE sites ALTER COLUMN id ADD INDEX;
+ALTER TABLE sites ALTER COLUMN region_id ADD INDEX;
 ALTER TABLE sites ALTER COLUMN name ADD INDEX;
 
 CREATE TABLE 'channels' (
+                            tenant_id SYMBOL CAPACITY 100 CACHE,
                             id SYMBOL CAPACITY 1000 CACHE, -- Assume normally 10 channels per site
                             site_id SYMBOL CAPACITY 100 CACHE,
                             name SYMBOL CAPACITY 1000 CACHE,
@@ -21,6 +45,7 @@ ALTER TABLE channels ALTER COLUMN site_id ADD INDEX;
 ALTER TABLE channels ALTER COLUMN name ADD INDEX;
 
 CREATE TABLE 'fleets' (
+                          tenant_id SYMBOL CAPACITY 100 CACHE,
                           id SYMBOL CAPACITY 5000 CACHE, -- Assume normally 50 fleets per site
                           site_id SYMBOL CAPACITY 100 CACHE,
                           name SYMBOL CAPACITY 5000 CACHE,
@@ -32,6 +57,7 @@ ALTER TABLE fleets ALTER COLUMN site_id ADD INDEX;
 ALTER TABLE fleets ALTER COLUMN name ADD INDEX;
 
 CREATE TABLE 'talk_groups' (
+                               tenant_id SYMBOL CAPACITY 100 CACHE,
                                id SYMBOL CAPACITY 10000 CACHE, -- Assume 100 talk groups per site
                                site_id SYMBOL CAPACITY 100 CACHE,
                                fleet_id SYMBOL CAPACITY 5000 CACHE,
@@ -45,6 +71,7 @@ ALTER TABLE talk_groups ALTER COLUMN fleet_id ADD INDEX;
 ALTER TABLE talk_groups ALTER COLUMN name ADD INDEX;
 
 CREATE TABLE 'units' (
+                         tenant_id SYMBOL CAPACITY 100 CACHE,
                          id SYMBOL CAPACITY 50000 CACHE, -- Assume 500 units per site
                          site_id SYMBOL CAPACITY 100 CACHE,
                          talk_group_id SYMBOL CAPACITY 10000 CACHE,
@@ -57,22 +84,152 @@ ALTER TABLE units ALTER COLUMN site_id ADD INDEX;
 ALTER TABLE units ALTER COLUMN talk_group_id ADD INDEX;
 ALTER TABLE units ALTER COLUMN name ADD INDEX;
 
+CREATE TABLE 'consoles' (
+                            tenant_id SYMBOL CAPACITY 100 CACHE,
+                            id SYMBOL CAPACITY 5000 CACHE, -- Assume 1 console per fleet
+                            site_id SYMBOL CAPACITY 100 CACHE,
+                            fleet_id SYMBOL CAPACITY 5000 CACHE,
+                            name SYMBOL CAPACITY 5000 CACHE,
+                            status LONG,
+                            timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE consoles ALTER COLUMN id ADD INDEX;
+ALTER TABLE consoles ALTER COLUMN site_id ADD INDEX;
+ALTER TABLE consoles ALTER COLUMN fleet_id ADD INDEX;
+
 CREATE TABLE 'calls' (
+                         tenant_id SYMBOL CAPACITY 100 CACHE,
                          -- Purposely set this field as STRING, as SYMBOL causing ingestion overhead
                          -- and we dont want to search these individual records.
                          id STRING,
+                         region_id SYMBOL CAPACITY 100 CACHE,
                          site_id SYMBOL CAPACITY 100 CACHE,
                          channel_id SYMBOL CAPACITY 10000 CACHE,
                          fleet_id SYMBOL CAPACITY 10000 CACHE,
                          source_unit_id SYMBOL CAPACITY 50000 CACHE,
+                         source_unit_home_site_id SYMBOL CAPACITY 100 CACHE, -- Differs from site_id when the unit is roaming
+                         source_console_id SYMBOL CAPACITY 5000 CACHE, -- Set instead of source_unit_id for console originated calls
                          destination_talk_group_id SYMBOL CAPACITY 10000 CACHE,
                          started_at TIMESTAMP,
                          ended_at TIMESTAMP,
-                         duration_second LONG
+                         duration_second LONG,
+                         rssi_dbm DOUBLE,
+                         snr_db DOUBLE,
+                         ber_pct DOUBLE,
+                         audio_quality DOUBLE -- Mean Opinion Score [1, 5]
 ) timestamp (started_at) PARTITION BY DAY;
 -- ALTER TABLE calls ALTER COLUMN id ADD INDEX;
+ALTER TABLE calls ALTER COLUMN region_id ADD INDEX;
 ALTER TABLE calls ALTER COLUMN site_id ADD INDEX;
 ALTER TABLE calls ALTER COLUMN channel_id ADD INDEX;
 ALTER TABLE calls ALTER COLUMN fleet_id ADD INDEX;
 ALTER TABLE calls ALTER COLUMN source_unit_id ADD INDEX;
 ALTER TABLE calls ALTER COLUMN destination_talk_group_id ADD INDEX;
+ALTER TABLE calls ALTER COLUMN source_unit_home_site_id ADD INDEX;
+ALTER TABLE calls ALTER COLUMN source_console_id ADD INDEX;
+
+CREATE TABLE 'unit_registrations' (
+                                      tenant_id SYMBOL CAPACITY 100 CACHE,
+                                      unit_id SYMBOL CAPACITY 50000 CACHE,
+                                      site_id SYMBOL CAPACITY 100 CACHE,
+                                      previous_site_id SYMBOL CAPACITY 100 CACHE,
+                                      home_site_id SYMBOL CAPACITY 100 CACHE,
+                                      roaming BOOLEAN,
+                                      timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE unit_registrations ALTER COLUMN unit_id ADD INDEX;
+ALTER TABLE unit_registrations ALTER COLUMN site_id ADD INDEX;
+ALTER TABLE unit_registrations ALTER COLUMN home_site_id ADD INDEX;
+
+CREATE TABLE 'channel_readings' (
+                                    tenant_id SYMBOL CAPACITY 100 CACHE,
+                                    channel_id SYMBOL CAPACITY 10000 CACHE,
+                                    site_id SYMBOL CAPACITY 100 CACHE,
+                                    noise_floor_dbm DOUBLE,
+                                    interference_dbm DOUBLE,
+                                    utilisation_pct DOUBLE,
+                                    timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE channel_readings ALTER COLUMN channel_id ADD INDEX;
+ALTER TABLE channel_readings ALTER COLUMN site_id ADD INDEX;
+
+CREATE TABLE 'site_equipment_readings' (
+                                           tenant_id SYMBOL CAPACITY 100 CACHE,
+                                           site_id SYMBOL CAPACITY 100 CACHE,
+                                           state SYMBOL CAPACITY 4 CACHE, -- normal, degrading, outage
+                                           pa_temperature_c DOUBLE,
+                                           forward_power_w DOUBLE,
+                                           reflected_power_w DOUBLE,
+                                           vswr DOUBLE,
+                                           mains_power BOOLEAN,
+                                           battery_pct DOUBLE,
+                                           backhaul_latency_ms DOUBLE, -- NULL when the backhaul link is down
+                                           timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE site_equipment_readings ALTER COLUMN site_id ADD INDEX;
+
+CREATE TABLE 'alarms' (
+                          tenant_id SYMBOL CAPACITY 100 CACHE,
+                          id STRING, -- Same id for the raise and clear records of an alarm
+                          site_id SYMBOL CAPACITY 100 CACHE,
+                          code SYMBOL CAPACITY 100 CACHE,
+                          severity SYMBOL CAPACITY 10 CACHE,
+                          source_type SYMBOL CAPACITY 10 CACHE,
+                          source_id SYMBOL CAPACITY 1000 CACHE,
+                          event SYMBOL CAPACITY 2 CACHE, -- raise, clear
+                          raised_at TIMESTAMP,
+                          cleared_at TIMESTAMP, -- Only set on clear records
+                          duration_sec LONG, -- Only set on clear records
+                          timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE alarms ALTER COLUMN site_id ADD INDEX;
+ALTER TABLE alarms ALTER COLUMN code ADD INDEX;
+ALTER TABLE alarms ALTER COLUMN source_id ADD INDEX;
+
+CREATE TABLE 'messages' (
+                            tenant_id SYMBOL CAPACITY 100 CACHE,
+                            id STRING,
+                            site_id SYMBOL CAPACITY 100 CACHE,
+                            source_unit_id SYMBOL CAPACITY 50000 CACHE,
+                            destination_unit_id SYMBOL CAPACITY 50000 CACHE, -- Only set for unit to unit messages
+                            destination_talk_group_id SYMBOL CAPACITY 10000 CACHE, -- Only set for group messages
+                            type SYMBOL CAPACITY 10 CACHE, -- status, sds_text, location
+                            delivery_status SYMBOL CAPACITY 10 CACHE, -- delivered, failed, expired
+                            payload_bytes LONG,
+                            latency_ms LONG,
+                            timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE messages ALTER COLUMN site_id ADD INDEX;
+ALTER TABLE messages ALTER COLUMN source_unit_id ADD INDEX;
+
+CREATE TABLE 'console_sessions' (
+                                    tenant_id SYMBOL CAPACITY 100 CACHE,
+                                    id STRING, -- Same id for the login and logout records of a session
+                                    console_id SYMBOL CAPACITY 5000 CACHE,
+                                    site_id SYMBOL CAPACITY 100 CACHE,
+                                    fleet_id SYMBOL CAPACITY 5000 CACHE,
+                                    operator SYMBOL CAPACITY 10000 CACHE,
+                                    event SYMBOL CAPACITY 2 CACHE, -- login, logout
+                                    login_at TIMESTAMP,
+                                    logout_at TIMESTAMP, -- Only set on logout records
+                                    duration_sec LONG, -- Only set on logout records
+                                    timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE console_sessions ALTER COLUMN console_id ADD INDEX;
+ALTER TABLE console_sessions ALTER COLUMN site_id ADD INDEX;
+
+CREATE TABLE 'talk_group_patches' (
+                                      tenant_id SYMBOL CAPACITY 100 CACHE,
+                                      id STRING, -- Same id for the patch and unpatch records
+                                      site_id SYMBOL CAPACITY 100 CACHE,
+                                      console_id SYMBOL CAPACITY 5000 CACHE,
+                                      talk_group_id SYMBOL CAPACITY 10000 CACHE,
+                                      patched_talk_group_id SYMBOL CAPACITY 10000 CACHE,
+                                      event SYMBOL CAPACITY 2 CACHE, -- patch, unpatch
+                                      patched_at TIMESTAMP,
+                                      unpatched_at TIMESTAMP, -- Only set on unpatch records
+                                      duration_sec LONG, -- Only set on unpatch records
+                                      timestamp TIMESTAMP
+) timestamp (timestamp) PARTITION BY DAY;
+ALTER TABLE talk_group_patches ALTER COLUMN site_id ADD INDEX;
+ALTER TABLE talk_group_patches ALTER COLUMN console_id ADD INDEX;
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dropServer is a fake QuestDB which closes its first drops connections after receiving dropAfter rows,
+// then stops listening for down before accepting the next connection.
+type dropServer struct {
+	t         *testing.T
+	addr      string
+	dropAfter int
+	drops     int
+	down      time.Duration
+	dropped   chan struct{} // Receives after each dropped connection
+
+	mu   sync.Mutex
+	rows []string
+	stop bool
+}
+
+func newDropServer(t *testing.T, dropAfter, drops int, down time.Duration) *dropServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dropServer{t: t, addr: ln.Addr().String(), dropAfter: dropAfter, drops: drops, down: down, dropped: make(chan struct{}, 16)}
+	go s.serve(ln)
+	t.Cleanup(func() {
+		s.mu.Lock()
+		s.stop = true
+		s.mu.Unlock()
+	})
+	return s
+}
+
+func (s *dropServer) serve(ln net.Listener) {
+	for drops := 0; ; drops++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		r := bufio.NewReader(conn)
+		for n := 0; drops >= s.drops || n < s.dropAfter; n++ {
+			row, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			s.mu.Lock()
+			s.rows = append(s.rows, strings.TrimSuffix(row, "\n"))
+			s.mu.Unlock()
+		}
+		_ = conn.Close()
+		_ = ln.Close()
+		s.dropped <- struct{}{}
+
+		time.Sleep(s.down)
+		s.mu.Lock()
+		stop := s.stop
+		s.mu.Unlock()
+		if stop {
+			return
+		}
+		if ln, err = net.Listen("tcp", s.addr); err != nil {
+			s.t.Errorf("failed to listen again: %s", err)
+			return
+		}
+	}
+}
+
+func (s *dropServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.rows...)
+}
+
+// waitDropped waits until the server dropped the connection and the c noticed it.
+func (s *dropServer) waitDropped(t *testing.T, c *questdbConn) {
+	closed := c.closed
+	select {
+	case <-s.dropped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not dropped")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closed connection was not noticed")
+	}
+}
+
+func row(i int) string {
+	return fmt.Sprintf("t n=%di", i)
+}
+
+func writeRows(t *testing.T, c *questdbConn, from, to int) {
+	for i := from; i < to; i++ {
+		if _, err := c.Write([]byte(row(i) + "\n")); err != nil {
+			t.Fatalf("failed to write row %d: %s", i, err)
+		}
+	}
+}
+
+func assertReceived(t *testing.T, s *dropServer, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	rows := s.received()
+	for len(rows) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		rows = s.received()
+	}
+	if len(rows) != n {
+		t.Fatalf("received %d rows, want %d", len(rows), n)
+	}
+	for i, r := range rows {
+		if r != row(i) {
+			t.Fatalf("received %q at %d, want %q", r, i, row(i))
+		}
+	}
+}
+
+func TestQuestdbConnReconnectsHistorical(t *testing.T) {
+	reconnectTimeout = 10 * time.Second
+	s := newDropServer(t, 10, 1, 1500*time.Millisecond)
+	c, err := newQuestdbConn(context.Background(), s.addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	writeRows(t, c, 0, 10)
+	s.waitDropped(t, c)
+	started := time.Now()
+	writeRows(t, c, 10, 20) // Blocks until reconnected
+	if time.Since(started) < time.Second {
+		t.Errorf("reconnected after %s, want after the server was down", time.Since(started))
+	}
+	assertReceived(t, s, 20)
+}
+
+func TestQuestdbConnBuffersLive(t *testing.T) {
+	reconnectTimeout = 10 * time.Second
+	ingestion = newIngestStats()
+	s := newDropServer(t, 10, 1, 1500*time.Millisecond)
+	c, err := newQuestdbConn(context.Background(), s.addr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	writeRows(t, c, 0, 10)
+	s.waitDropped(t, c)
+	started := time.Now()
+	writeRows(t, c, 10, 20) // Buffered while the server is down
+	if time.Since(started) > 500*time.Millisecond {
+		t.Errorf("live writes were blocked for %s while disconnected", time.Since(started))
+	}
+	if c.conn != nil || len(c.backlog) == 0 {
+		t.Fatal("rows were not buffered while disconnected")
+	}
+	if rows := ingestion.rows["t"]; rows != 10 {
+		t.Errorf("%d rows flushed while disconnected, want the 10 rows sent", rows)
+	}
+
+	time.Sleep(2 * time.Second) // Server is up and the backoff is over
+	writeRows(t, c, 20, 25)     // Backlog is sent first
+	assertReceived(t, s, 25)
+	if c.lost != 0 {
+		t.Errorf("lost %d rows, want none", c.lost)
+	}
+	if rows := ingestion.rows["t"]; rows != 25 {
+		t.Errorf("%d rows flushed, want 25", rows)
+	}
+}
+
+// brokenConn accepts n bytes, then fails like a broken connection.
+type brokenConn struct {
+	net.Conn
+	n   int
+	got []byte
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	n := len(p)
+	if n > c.n {
+		n = c.n
+	}
+	c.got = append(c.got, p[:n]...)
+	c.n -= n
+	if n < len(p) {
+		return n, errors.New("broken pipe")
+	}
+	return n, nil
+}
+
+func (c *brokenConn) Close() error { return nil }
+
+func TestQuestdbConnDropsPartialRow(t *testing.T) {
+	ingestion = newIngestStats()
+	conn := &brokenConn{n: len("a\\ b n=1i\nc n=2")}
+	c := &questdbConn{live: true, backlogCap: 1024, conn: conn}
+
+	if _, err := c.Write([]byte("a\\ b n=1i\nc n=2i\nd n=3i\n")); err != nil {
+		t.Fatal(err)
+	}
+	if string(c.backlog) != "d n=3i\n" {
+		t.Errorf("got backlog %q, want the rows after the partial row", c.backlog)
+	}
+	if c.lost != 1 || ingestion.lostRows != 1 {
+		t.Errorf("lost %d rows, reported %d lost rows, want the partial row lost", c.lost, ingestion.lostRows)
+	}
+	if len(ingestion.rows) != 1 || ingestion.rows["a b"] != 1 {
+		t.Errorf("got flushed rows %v, want only the row sent", ingestion.rows)
+	}
+	if ingestion.bytes != int64(len(conn.got)) {
+		t.Errorf("got %d flushed bytes, want the %d bytes sent", ingestion.bytes, len(conn.got))
+	}
+}
+
+func TestQuestdbConnReconnectTimeout(t *testing.T) {
+	reconnectTimeout = 1500 * time.Millisecond
+	s := newDropServer(t, 1, 1, time.Hour)
+	c, err := newQuestdbConn(context.Background(), s.addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	writeRows(t, c, 0, 1)
+	s.waitDropped(t, c)
+	_, err = c.Write([]byte(row(1) + "\n"))
+	if code := exitCode(err); code != exitConnectionError {
+		t.Fatalf("got error %v with exit code %d, want exit code %d", err, code, exitConnectionError)
+	}
+	if _, err := c.Write([]byte(row(2) + "\n")); err == nil {
+		t.Error("write succeeded after the reconnect failed")
+	}
+}
+
+func TestQuestdbConnInterrupted(t *testing.T) {
+	reconnectTimeout = time.Hour
+	s := newDropServer(t, 1, 1, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := newQuestdbConn(ctx, s.addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	writeRows(t, c, 0, 1)
+	s.waitDropped(t, c)
+	time.AfterFunc(100*time.Millisecond, cancel)
+	started := time.Now()
+	_, err = c.Write([]byte(row(1) + "\n"))
+	if code := exitCode(err); code != exitConnectionError {
+		t.Fatalf("got error %v with exit code %d, want exit code %d", err, code, exitConnectionError)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("interrupted after %s", time.Since(started))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// getRegionConfigs returns the region configs from the "regions-file" if provided,
+// otherwise "sites" are evenly split into "regions" regions.
+// Regions in the regions file without "sites" have "sites" sites.
+func getRegionConfigs() ([]generator.RegionConfig, error) {
+	var configs []generator.RegionConfig
+	if fRegionsFile != "" {
+		b, err := ioutil.ReadFile(fRegionsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read regions file: %w", err)
+		}
+		if err := json.Unmarshal(b, &configs); err != nil {
+			return nil, fmt.Errorf("failed to decode regions file: %w", err)
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("no region found in regions file")
+		}
+		for i := range configs {
+			if configs[i].Sites == 0 {
+				configs[i].Sites = fNoOfSites
+			}
+		}
+	} else {
+		if fNoOfRegions <= 0 {
+			return nil, fmt.Errorf("number of regions must be positive")
+		}
+		configs = make([]generator.RegionConfig, fNoOfRegions)
+		for i := range configs {
+			configs[i].Sites = fNoOfSites / fNoOfRegions
+			if i < fNoOfSites%fNoOfRegions {
+				configs[i].Sites++
+			}
+		}
+	}
+	return configs, nil
+}
+
+// loadStaticRegions decodes regions from the static JSON file.
+// Static files written before regions were introduced only contain the list of sites,
+// these sites are put into a single default region.
+func loadStaticRegions(b []byte) ([]*model.Region, error) {
+	var regions []*model.Region
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return nil, err
+	}
+	for _, r := range regions {
+		if len(r.Sites) > 0 {
+			return regions, nil
+		}
+	}
+
+	var sites []*model.Site
+	if err := json.Unmarshal(b, &sites); err != nil {
+		return nil, err
+	}
+	region := &model.Region{
+		Id:     fake.UUID(),
+		Name:   "Region#Default",
+		Status: model.StatusActive,
+		Sites:  sites,
+	}
+	for _, site := range sites {
+		site.RegionId = region.Id
+	}
+	return []*model.Region{region}, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ingestion collects the statistics of the ILP messages flushed by all outputs, for the run summary and the "report-file".
+var ingestion = newIngestStats()
+
+// ingestStats are the statistics of the flushes of all outputs and workers.
+// Rows are counted by the output building them, and added to the statistics when they're flushed.
+type ingestStats struct {
+	mu          sync.Mutex
+	start       time.Time
+	outputs     []*output
+	rows        map[string]int // Flushed rows by table
+	bytes       int64
+	latencies   []time.Duration
+	throughput  []throughput // By second since start
+	flushErrors int
+	reconnects  int // Of the QuestDB connections
+	lostRows    int // Dropped while disconnected from QuestDB
+	errors      []string
+	loadFactors map[string]float64 // Load factor of each site at its last step, by site ID
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// tableRows counts the rows of a table built by an output.
+// Generated rows are counted by the worker owning the output, and are read by the metrics endpoint.
+type tableRows struct {
+	generated int64 // Atomic
+	flushed   int64 // Under the lock of ingestStats
+}
+
+type throughput struct {
+	Second int   `json:"second"`
+	Rows   int   `json:"rows"`
+	Bytes  int64 `json:"bytes"`
+}
+
+func newIngestStats() *ingestStats {
+	return &ingestStats{start: time.Now(), rows: make(map[string]int), loadFactors: make(map[string]float64)}
+}
+
+// add adds the output to the statistics, so its rows are reported.
+func (s *ingestStats) add(o *output) *output {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.rows = make(map[string]*tableRows)
+	s.outputs = append(s.outputs, o)
+	return o
+}
+
+// addTable adds the table to the rows of the output. Only the worker owning the output adds tables to it,
+// while holding the lock, so the rows of outputs can be read by other goroutines holding the lock.
+func (s *ingestStats) addTable(o *output, table string) *tableRows {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := &tableRows{}
+	o.rows[table] = rows
+	return rows
+}
+
+// flushed records a flush of bytes by the output, including the rows built since its last flush.
+func (s *ingestStats) flushed(o *output, bytes int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := 0
+	for table, r := range o.rows {
+		n := atomic.LoadInt64(&r.generated) - r.flushed
+		r.flushed += n
+		s.rows[table] += int(n)
+		rows += int(n)
+	}
+	s.record(rows, bytes, latency)
+	if err != nil {
+		s.flushErrors++
+		s.errors = append(s.errors, err.Error())
+	}
+}
+
+// sent records ILP messages which reached a QuestDB connection. Their rows are counted by table from the messages,
+// as rows of a QuestDB output are sent later than flushed while reconnecting, or dropped.
+// A row sent partially isn't counted.
+func (s *ingestStats) sent(p []byte, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, rows := len(p), 0
+	var table []byte // Table of the last rows, counted once the table changes
+	tableRows := 0
+	for len(p) > 0 {
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			break
+		}
+		if name := ilpTable(p[:end]); !bytes.Equal(name, table) {
+			if tableRows > 0 {
+				s.rows[unescapeILPName(table)] += tableRows
+			}
+			table, tableRows = name, 0
+		}
+		tableRows++
+		rows++
+		p = p[end+1:]
+	}
+	if tableRows > 0 {
+		s.rows[unescapeILPName(table)] += tableRows
+	}
+	s.record(rows, size, latency)
+}
+
+// record records a flush of rows and bytes, the lock must be held.
+func (s *ingestStats) record(rows, bytes int, latency time.Duration) {
+	s.bytes += int64(bytes)
+	s.latencies = append(s.latencies, latency)
+	sec := int(time.Since(s.start) / time.Second)
+	for len(s.throughput) <= sec {
+		s.throughput = append(s.throughput, throughput{Second: len(s.throughput)})
+	}
+	s.throughput[sec].Rows += rows
+	s.throughput[sec].Bytes += int64(bytes)
+}
+
+// ilpTable returns the table name of the ILP row, which ends at the first unescaped comma or space.
+func ilpTable(row []byte) []byte {
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '\\':
+			i++
+		case ',', ' ':
+			return row[:i]
+		}
+	}
+	return row
+}
+
+// unescapeILPName returns the name with its escaped spaces and equal signs, as names can't contain backslashes.
+func unescapeILPName(name []byte) string {
+	return strings.ReplaceAll(string(name), "\\", "")
+}
+
+// stepped records the load factors of the sites of a worker at its last step.
+func (s *ingestStats) stepped(loadFactors map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for site, f := range loadFactors {
+		s.loadFactors[site] = f
+	}
+}
+
+// disconnected records a broken QuestDB connection as a failed flush.
+func (s *ingestStats) disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushErrors++
+	s.errors = append(s.errors, err.Error())
+}
+
+func (s *ingestStats) reconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnects++
+}
+
+func (s *ingestStats) dropped(rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lostRows += rows
+}
+
+func (s *ingestStats) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, err.Error())
+}
+
+// ingestReport is the JSON report of a run, to compare the ingestion of runs against different QuestDB versions.
+type ingestReport struct {
+	Args           []string       `json:"args"`
+	StartedAt      time.Time      `json:"startedAt"`
+	DurationSec    float64        `json:"durationSec"`
+	Rows           int            `json:"rows"`
+	RowsPerTable   map[string]int `json:"rowsPerTable"`
+	Bytes          int64          `json:"bytes"`
+	Flushes        int            `json:"flushes"`
+	RowsPerSec     float64        `json:"rowsPerSec"`
+	MBPerSec       float64        `json:"mbPerSec"`
+	FlushLatencyMs latencyReport  `json:"flushLatencyMs"`
+	Throughput     []throughput   `json:"throughput"` // Rows and bytes flushed in each second of the run
+	Reconnects     int            `json:"reconnects"`
+	LostRows       int            `json:"lostRows"` // Dropped while disconnected from QuestDB
+	Errors         []string       `json:"errors"`
+}
+
+type latencyReport struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func (s *ingestStats) report() ingestReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := ingestReport{
+		Args:         os.Args[1:],
+		StartedAt:    s.start,
+		DurationSec:  time.Since(s.start).Seconds(),
+		RowsPerTable: make(map[string]int, len(s.rows)),
+		Bytes:        s.bytes,
+		Flushes:      len(s.latencies),
+		Throughput:   append([]throughput{}, s.throughput...),
+		Reconnects:   s.reconnects,
+		LostRows:     s.lostRows,
+		Errors:       append([]string{}, s.errors...),
+	}
+	for table, n := range s.rows {
+		r.RowsPerTable[table] = n
+		r.Rows += n
+	}
+	r.RowsPerSec = float64(r.Rows) / r.DurationSec
+	r.MBPerSec = float64(r.Bytes) / r.DurationSec / 1024 / 1024
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	r.FlushLatencyMs = latencyReport{
+		P50: ms(percentile(sorted, 0.5)),
+		P95: ms(percentile(sorted, 0.95)),
+		P99: ms(percentile(sorted, 0.99)),
+		Max: ms(percentile(sorted, 1)),
+	}
+	return r
+}
+
+// close logs the summary of the run, and writes the JSON report to the file if it's not empty.
+// It's deferred by main, so the report is written even if the run fails. It's also called when the shutdown
+// times out, so only the first call writes the summary and the report, the next ones return its error.
+func (s *ingestStats) close(file string) error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.writeSummary(file)
+	})
+	return s.closeErr
+}
+
+func (s *ingestStats) writeSummary(file string) error {
+	r := s.report()
+	log.Printf("Ingestion summary:")
+	log.Printf("   + Rows: %d, bytes: %d, flushes: %d, errors: %d", r.Rows, r.Bytes, r.Flushes, len(r.Errors))
+	if r.Reconnects > 0 || r.LostRows > 0 {
+		log.Printf("   + Reconnects to QuestDB: %d, lost rows: %d", r.Reconnects, r.LostRows)
+	}
+	log.Printf("   + Rows/sec: %.0f, MB/sec: %.2f", r.RowsPerSec, r.MBPerSec)
+	log.Printf("   + Flush latency (ms): p50=%.3f, p95=%.3f, p99=%.3f, max=%.3f",
+		r.FlushLatencyMs.P50, r.FlushLatencyMs.P95, r.FlushLatencyMs.P99, r.FlushLatencyMs.Max)
+	tables := make([]string, 0, len(r.RowsPerTable))
+	for table := range r.RowsPerTable {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		log.Printf("   + %s: %d rows", table, r.RowsPerTable[table])
+	}
+	if file == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(file, b, 0666)
+	}
+	if err != nil {
+		return writeError(err, "failed to write report file")
+	}
+	log.Printf("Report written to: %s", file)
+	return nil
+}
+
+// statsWriter records the writes of the ILP encoder of a file output as flushes,
+// including the ones made by the encoder itself when its buffer is full.
+type statsWriter struct {
+	w io.Writer
+	o *output
+}
+
+func (w *statsWriter) Write(p []byte) (int, error) {
+	started := time.Now()
+	n, err := w.w.Write(p)
+	ingestion.flushed(w.o, n, time.Since(started), err)
+	return n, err
+}
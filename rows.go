@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// sinkHandler writes the generated rows to the sinks of their tenants.
+// Rows are flushed every "flush-batch-size" calls, at every step in live mode, or paced by the pacer in rate mode.
+// Checkpoints are only saved by flushes at the end of a step, when the state of the generator is valid.
+type sinkHandler struct {
+	ctx         context.Context
+	ss          sinks
+	live        bool
+	backfilling bool // Live mode generating the historical metrics up to now first
+	calls       int  // Calls written since the last flush
+	rows        int  // Rows written since the last flush
+	totalCalls  int
+	worker      int
+	logPrefix   string // Worker of the handler when running multiple workers
+	gen         *generator.CallGenerator
+	checkpoints *checkpointer // Nil if checkpoints are disabled
+	pacer       *pacer        // Nil if no target rate
+	metrics     bool          // Whether the load factors are recorded for the metrics endpoint
+}
+
+func (h *sinkHandler) HandleRow(r generator.Row) error {
+	if err := h.saveRow(r); err != nil {
+		return err
+	}
+	h.rows++
+	// With checkpoints, paced rows are flushed at the end of the step instead, as checkpoints are only valid between steps
+	if h.pacer != nil && h.checkpoints == nil && h.rows >= h.pacer.batch {
+		return h.flush(fmt.Sprintf("%d paced rows", h.rows))
+	}
+	return nil
+}
+
+func (h *sinkHandler) saveRow(r generator.Row) error {
+	s := h.ss[r.TenantId]
+	switch v := r.Value.(type) {
+	case *model.Call:
+		h.calls++
+		return saveCall(h.ctx, s, v)
+	case *model.ChannelReading:
+		return saveChannelReading(h.ctx, s, v)
+	case *model.SiteEquipmentReading:
+		return saveEquipmentReading(h.ctx, s, v)
+	case *model.Alarm:
+		return saveAlarm(h.ctx, s, v)
+	case *model.Message:
+		return saveMessage(h.ctx, s, v)
+	case *model.ConsoleSession:
+		return saveConsoleSession(h.ctx, s, v)
+	case *model.TalkGroupPatch:
+		return saveTalkGroupPatch(h.ctx, s, v)
+	case *model.UnitRegistration:
+		return saveUnitRegistration(h.ctx, s, v)
+	}
+	return fmt.Errorf("unknown row type %T", r.Value)
+}
+
+func (h *sinkHandler) HandleStep(from, to time.Time) error {
+	if h.metrics {
+		ingestion.stepped(h.gen.LoadFactors())
+	}
+	if h.backfilling && time.Since(to) < interval { // Caught up with the wall clock
+		h.backfilling = false
+		log.Printf(" > %sBackfilled up to %s, continuing in real time", h.logPrefix, to.Format(time.RFC3339))
+	}
+	if h.live && !h.backfilling {
+		// Ingest at every tick, including other metrics written since the last calls flush
+		return h.flush(fmt.Sprintf("%d final call metrics at: %s", h.calls, to.Format(time.RFC3339)))
+	}
+	if h.pacer != nil && h.checkpoints != nil && h.rows >= h.pacer.batch {
+		return h.flushAndCheckpoint(fmt.Sprintf("%d paced rows", h.rows))
+	}
+	if h.pacer == nil && h.calls > fFlushBatchSize {
+		return h.flushAndCheckpoint(fmt.Sprintf("%d call metrics: start=%s, end=%s", h.calls, from.Format(time.RFC3339), end.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// flushAndCheckpoint flushes all sinks, then updates the checkpoint of the worker.
+// It must be called between steps (e.g. in HandleStep), as the state of the generator is only valid there.
+func (h *sinkHandler) flushAndCheckpoint(msg string) error {
+	if err := h.flush(msg); err != nil {
+		return err
+	}
+	if err := h.checkpoint(); err != nil {
+		return writeError(err, "failed to save checkpoint")
+	}
+	return nil
+}
+
+// flush flushes all sinks, msg describes the flushed calls.
+func (h *sinkHandler) flush(msg string) error {
+	if h.pacer != nil { // Paced flushes are reported by the pacer
+		bytes, started := h.ss.buffered(), time.Now()
+		if err := h.ss.flush(h.ctx); err != nil {
+			return err
+		}
+		h.pacer.wait(h.rows, bytes, time.Since(started))
+		h.totalCalls += h.calls
+	} else {
+		log.Printf(" > %sFlushing %s", h.logPrefix, msg)
+		if err := h.ss.flush(h.ctx); err != nil {
+			return err
+		}
+		h.totalCalls += h.calls
+		log.Printf("   + %s%d call metrics saved, totalSaved=%d", h.logPrefix, h.calls, h.totalCalls)
+	}
+	h.calls, h.rows = 0, 0
+	return nil
+}
+
+// checkpoint updates the checkpoint of the worker, all its rows must have been flushed.
+func (h *sinkHandler) checkpoint() error {
+	if h.checkpoints == nil {
+		return nil
+	}
+	return h.checkpoints.update(h.worker, h.gen.State(), h.ss, h.totalCalls)
+}
+
+func saveCall(ctx context.Context, s *sink, c *model.Call) error {
+	s.Table("calls").
+		Symbol("region_id", c.RegionId).
+		Symbol("site_id", c.SiteId).
+		Symbol("channel_id", c.ChannelId).
+		Symbol("fleet_id", c.FleetId).
+		Symbol("destination_talk_group_id", c.DestinationTalkGroupId)
+	if c.SourceConsoleId != "" {
+		s.Symbol("source_console_id", c.SourceConsoleId)
+	} else {
+		s.Symbol("source_unit_id", c.SourceUnitId).
+			Symbol("source_unit_home_site_id", c.SourceUnitHomeSiteId)
+	}
+	err := s.StringColumn("id", c.Id).
+		TimestampColumn("started_at", c.StartedAt.UnixNano()).
+		TimestampColumn("ended_at", c.EndedAt.UnixNano()).
+		Int64Column("duration_sec", c.DurationSecond).
+		Float64Column("rssi_dbm", c.RssiDbm).
+		Float64Column("snr_db", c.SnrDb).
+		Float64Column("ber_pct", c.BerPercent).
+		Float64Column("audio_quality", c.AudioQuality).
+		At(ctx, c.StartedAt.UnixNano())
+	return wrapSaveError(err, "calls")
+}
+
+func saveChannelReading(ctx context.Context, s *sink, r *model.ChannelReading) error {
+	err := s.Table("channel_readings").
+		Symbol("channel_id", r.ChannelId).
+		Symbol("site_id", r.SiteId).
+		Float64Column("noise_floor_dbm", r.NoiseFloorDbm).
+		Float64Column("interference_dbm", r.InterferenceDbm).
+		Float64Column("utilisation_pct", r.UtilisationPercent).
+		At(ctx, r.Timestamp.UnixNano())
+	return wrapSaveError(err, "channel_readings")
+}
+
+func saveEquipmentReading(ctx context.Context, s *sink, r *model.SiteEquipmentReading) error {
+	err := s.Table("site_equipment_readings").
+		Symbol("site_id", r.SiteId).
+		Symbol("state", r.State).
+		Float64Column("pa_temperature_c", r.PaTemperatureC).
+		Float64Column("forward_power_w", r.ForwardPowerW).
+		Float64Column("reflected_power_w", r.ReflectedPowerW).
+		Float64Column("vswr", r.Vswr).
+		BoolColumn("mains_power", r.MainsPower).
+		Float64Column("battery_pct", r.BatteryPercent).
+		Float64Column("backhaul_latency_ms", r.BackhaulLatencyMs).
+		At(ctx, r.Timestamp.UnixNano())
+	return wrapSaveError(err, "site_equipment_readings")
+}
+
+// saveAlarm saves a raise record for an active alarm, or a clear record for a cleared alarm.
+// Both records of an alarm have the same id and raised_at timestamp.
+func saveAlarm(ctx context.Context, s *sink, a *model.Alarm) error {
+	event, ts := "raise", a.RaisedAt
+	if !a.ClearedAt.IsZero() {
+		event, ts = "clear", a.ClearedAt
+	}
+	s.Table("alarms").
+		Symbol("site_id", a.SiteId).
+		Symbol("code", a.Code).
+		Symbol("severity", a.Severity).
+		Symbol("source_type", a.SourceType).
+		Symbol("source_id", a.SourceId).
+		Symbol("event", event).
+		StringColumn("id", a.Id).
+		TimestampColumn("raised_at", a.RaisedAt.UnixNano())
+	if event == "clear" {
+		s.TimestampColumn("cleared_at", a.ClearedAt.UnixNano()).
+			Int64Column("duration_sec", int64(a.ClearedAt.Sub(a.RaisedAt).Seconds()))
+	}
+	return wrapSaveError(s.At(ctx, ts.UnixNano()), "alarms")
+}
+
+func saveMessage(ctx context.Context, s *sink, m *model.Message) error {
+	s.Table("messages").
+		Symbol("site_id", m.SiteId).
+		Symbol("source_unit_id", m.SourceUnitId).
+		Symbol("type", m.Type).
+		Symbol("delivery_status", m.DeliveryStatus)
+	if m.DestinationUnitId != "" {
+		s.Symbol("destination_unit_id", m.DestinationUnitId)
+	} else {
+		s.Symbol("destination_talk_group_id", m.DestinationTalkGroupId)
+	}
+	err := s.StringColumn("id", m.Id).
+		Int64Column("payload_bytes", m.PayloadBytes).
+		Int64Column("latency_ms", m.LatencyMs).
+		At(ctx, m.SentAt.UnixNano())
+	return wrapSaveError(err, "messages")
+}
+
+// saveConsoleSession saves a login record for an active session, or a logout record for an ended session.
+func saveConsoleSession(ctx context.Context, s *sink, cs *model.ConsoleSession) error {
+	event, ts := "login", cs.LoginAt
+	if !cs.LogoutAt.IsZero() {
+		event, ts = "logout", cs.LogoutAt
+	}
+	s.Table("console_sessions").
+		Symbol("console_id", cs.ConsoleId).
+		Symbol("site_id", cs.SiteId).
+		Symbol("fleet_id", cs.FleetId).
+		Symbol("operator", cs.Operator).
+		Symbol("event", event).
+		StringColumn("id", cs.Id).
+		TimestampColumn("login_at", cs.LoginAt.UnixNano())
+	if event == "logout" {
+		s.TimestampColumn("logout_at", cs.LogoutAt.UnixNano()).
+			Int64Column("duration_sec", int64(cs.LogoutAt.Sub(cs.LoginAt).Seconds()))
+	}
+	return wrapSaveError(s.At(ctx, ts.UnixNano()), "console_sessions")
+}
+
+// saveTalkGroupPatch saves a patch record for an active patch, or an unpatch record for a removed patch.
+func saveTalkGroupPatch(ctx context.Context, s *sink, p *model.TalkGroupPatch) error {
+	event, ts := "patch", p.PatchedAt
+	if !p.UnpatchedAt.IsZero() {
+		event, ts = "unpatch", p.UnpatchedAt
+	}
+	s.Table("talk_group_patches").
+		Symbol("site_id", p.SiteId).
+		Symbol("console_id", p.ConsoleId).
+		Symbol("talk_group_id", p.TalkGroupId).
+		Symbol("patched_talk_group_id", p.PatchedTalkGroupId).
+		Symbol("event", event).
+		StringColumn("id", p.Id).
+		TimestampColumn("patched_at", p.PatchedAt.UnixNano())
+	if event == "unpatch" {
+		s.TimestampColumn("unpatched_at", p.UnpatchedAt.UnixNano()).
+			Int64Column("duration_sec", int64(p.UnpatchedAt.Sub(p.PatchedAt).Seconds()))
+	}
+	return wrapSaveError(s.At(ctx, ts.UnixNano()), "talk_group_patches")
+}
+
+func saveUnitRegistration(ctx context.Context, s *sink, r *model.UnitRegistration) error {
+	err := s.Table("unit_registrations").
+		Symbol("unit_id", r.UnitId).
+		Symbol("site_id", r.SiteId).
+		Symbol("previous_site_id", r.PreviousSiteId).
+		Symbol("home_site_id", r.HomeSiteId).
+		BoolColumn("roaming", r.SiteId != r.HomeSiteId).
+		At(ctx, r.RegisteredAt.UnixNano())
+	return wrapSaveError(err, "unit_registrations")
+}
+
+func wrapSaveError(err error, table string) error {
+	if err != nil {
+		return fmt.Errorf("failed to save %s record: %w", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lnquy/quest-ei/pkg/ilp"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+const (
+	tenantRoutingNone  = "none"  // All tenants are written to the same tables
+	tenantRoutingTable = "table" // Each tenant is written to its own tables, suffixed by the tenant's name
+	tenantRoutingFile  = "file"  // Each tenant is written to its own output file
+)
+
+// output is where ILP messages are flushed to, either QuestDB or a file.
+// Rows are written by the ILP encoder, which doesn't allocate per row.
+type output struct {
+	encoder *ilp.Encoder
+	conn    *questdbConn          // Nil for file outputs
+	file    *os.File              // Nil for QuestDB outputs
+	table   *tableRows            // Rows of the table of the row being built
+	rows    map[string]*tableRows // By table
+
+	buffered int64 // Bytes of the buffered rows, stored atomically by the worker owning the output for the metrics
+}
+
+// updateBuffered publishes the size of the buffered rows, after building a row or flushing.
+func (o *output) updateBuffered() {
+	atomic.StoreInt64(&o.buffered, int64(len(o.encoder.Messages())))
+}
+
+// newOutput returns the output flushing to the file, or to QuestDB directly if the file is empty.
+// The file is opened in append mode.
+func newOutput(ctx context.Context, file string) (*output, error) {
+	if file == "" {
+		conn, err := newQuestdbConn(ctx, fQuestdbAddr, fIsLive)
+		if err != nil {
+			return nil, connectionError(err, "failed to connect to QuestDB at "+fQuestdbAddr)
+		}
+		// The conn records the rows as flushed once sent, as they might be buffered or dropped while disconnected
+		o := &output{conn: conn}
+		o.encoder = ilp.NewEncoder(conn, fFlushBatchBufferMB*1024*1024)
+		if fCheckpointFile != "" {
+			// Rows sent to QuestDB can't be removed on resume, so they're only sent by flushes followed by a checkpoint
+			o.encoder.DisableAutoFlush()
+		}
+		return ingestion.add(o), nil
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, writeError(err, "failed to open file to write")
+	}
+	// This file then can be used on `tsbs_load_questdb --file qdb-data.ilp --workers 4`
+	o := &output{file: f}
+	o.encoder = ilp.NewEncoder(&statsWriter{w: f, o: o}, fFlushBatchBufferMB*1024*1024)
+	return ingestion.add(o), nil
+}
+
+// close flushes the buffered rows, then closes the output.
+// A QuestDB output waits until reconnected to send its backlog, if any.
+func (o *output) close() error {
+	err := o.encoder.Flush()
+	o.updateBuffered()
+	if o.file != nil {
+		if cerr := o.file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	o.conn.drain()
+	if cerr := o.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sink writes the rows of a tenant to an output.
+// Every row is tagged with the tenant ID, and tenants may share the same output.
+type sink struct {
+	*output
+	tenantId    string
+	tableSuffix string
+	tables      map[string]string // Suffixed table names, so they're not concatenated for every row
+}
+
+// Table starts a new row of the tenant's table.
+func (s *sink) Table(name string) *sink {
+	if s.tableSuffix != "" {
+		table, ok := s.tables[name]
+		if !ok {
+			table = name + s.tableSuffix
+			s.tables[name] = table
+		}
+		name = table
+	}
+	rows, ok := s.rows[name]
+	if !ok {
+		rows = ingestion.addTable(s.output, name)
+	}
+	s.table = rows
+	s.encoder.Table(name)
+	return s.Symbol("tenant_id", s.tenantId)
+}
+
+func (s *sink) Symbol(name, val string) *sink {
+	s.encoder.Symbol(name, val)
+	return s
+}
+
+func (s *sink) StringColumn(name, val string) *sink {
+	s.encoder.StringColumn(name, val)
+	return s
+}
+
+func (s *sink) Int64Column(name string, val int64) *sink {
+	s.encoder.Int64Column(name, val)
+	return s
+}
+
+func (s *sink) TimestampColumn(name string, ts int64) *sink {
+	s.encoder.TimestampColumn(name, ts)
+	return s
+}
+
+func (s *sink) Float64Column(name string, val float64) *sink {
+	s.encoder.Float64Column(name, val)
+	return s
+}
+
+func (s *sink) BoolColumn(name string, val bool) *sink {
+	s.encoder.BoolColumn(name, val)
+	return s
+}
+
+// At finalizes the row with the ts timestamp in Epoch nanoseconds.
+// Invalid rows (e.g. names from the topology CSV files with illegal chars) are config errors,
+// other errors are from flushing the full buffer.
+func (s *sink) At(ctx context.Context, ts int64) error {
+	atomic.AddInt64(&s.table.generated, 1)
+	err := s.encoder.At(ts)
+	s.updateBuffered()
+	if err == nil {
+		return nil
+	}
+	ingestion.failed(err)
+	if errors.Is(err, ilp.ErrInvalidMsg) { // Discarded row
+		atomic.AddInt64(&s.table.generated, -1)
+		return configError(err, "invalid record")
+	}
+	return writeError(err, "failed to write ILP messages")
+}
+
+// sinks are the sinks of all tenants, by tenant ID.
+type sinks map[string]*sink
+
+// newSinks creates the sinks of the tenants routed by the "tenant-routing" mode.
+func newSinks(ctx context.Context, tenants []*model.Tenant) (sinks, error) {
+	return newFileSinks(ctx, tenants, fOutMetricsFile)
+}
+
+// newWorkerSinks creates the sinks of a worker, so each worker writes to its own QuestDB connection
+// or its own shard of the "out-metrics-file" (e.g. metrics.1.ilp for the worker#1).
+func newWorkerSinks(ctx context.Context, tenants []*model.Tenant, worker int) (sinks, error) {
+	file := fOutMetricsFile
+	if file != "" {
+		ext := filepath.Ext(file)
+		file = strings.TrimSuffix(file, ext) + "." + strconv.Itoa(worker) + ext
+	}
+	return newFileSinks(ctx, tenants, file)
+}
+
+// newFileSinks creates the sinks of the tenants writing to the file (or QuestDB if empty).
+// The outputs already opened are closed if another one fails to open.
+func newFileSinks(ctx context.Context, tenants []*model.Tenant, file string) (sinks, error) {
+	ss := make(sinks, len(tenants))
+	var shared *output // Not opened when every tenant has its own file
+	for _, t := range tenants {
+		s := &sink{tenantId: t.Id}
+		if fTenantRouting == tenantRoutingFile {
+			ext := filepath.Ext(file)
+			o, err := newOutput(ctx, strings.TrimSuffix(file, ext)+"."+t.Slug+ext)
+			if err != nil {
+				_ = ss.close()
+				return nil, err
+			}
+			s.output = o
+			ss[t.Id] = s
+			continue
+		}
+		if shared == nil {
+			o, err := newOutput(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+			shared = o
+		}
+		s.output = shared
+		if fTenantRouting == tenantRoutingTable {
+			s.tableSuffix = "_" + t.Slug
+			s.tables = make(map[string]string)
+		}
+		ss[t.Id] = s
+	}
+	return ss, nil
+}
+
+// outputs returns the distinct outputs of the sinks.
+func (ss sinks) outputs() []*output {
+	outputs := make([]*output, 0, len(ss))
+	seen := make(map[*output]bool, len(ss))
+	for _, s := range ss {
+		if !seen[s.output] {
+			seen[s.output] = true
+			outputs = append(outputs, s.output)
+		}
+	}
+	return outputs
+}
+
+// flush flushes the buffered ILP messages of all outputs.
+func (ss sinks) flush(ctx context.Context) error {
+	for _, o := range ss.outputs() {
+		if err := flushILPMessages(ctx, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buffered returns the size of the buffered ILP messages of all outputs.
+func (ss sinks) buffered() int {
+	n := 0
+	for _, o := range ss.outputs() {
+		n += len(o.encoder.Messages())
+	}
+	return n
+}
+
+// close flushes and closes all outputs. The first error is returned, the next ones are logged.
+func (ss sinks) close() error {
+	var err error
+	for _, o := range ss.outputs() {
+		cerr := o.close()
+		if cerr == nil {
+			continue
+		}
+		if err != nil {
+			log.Printf(" > Failed to close output: %s", cerr)
+			continue
+		}
+		err = writeError(cerr, "failed to close output")
+	}
+	return err
+}
+
+// flushILPMessages flushes the buffered ILP messages of the output.
+// Flushes are recorded to the ingestion statistics by the writer of the encoder.
+func flushILPMessages(ctx context.Context, o *output) error {
+	err := o.encoder.Flush()
+	o.updateBuffered()
+	if err != nil {
+		return writeError(err, "failed to write ILP messages")
+	}
+	return nil
+}
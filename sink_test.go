@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSinksCloseReturnsFlushError(t *testing.T) {
+	ctx := context.Background()
+	o, err := newOutput(ctx, filepath.Join(t.TempDir(), "metrics.ilp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sinks{"tenant": &sink{output: o, tenantId: "tenant"}}
+	if err := ss["tenant"].Table("t").Int64Column("a", 1).At(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = o.file.Close() // The last flush fails
+
+	err = ss.close()
+	if code := exitCode(err); code != exitWriteError {
+		t.Fatalf("got error %v with exit code %d, want exit code %d", err, code, exitWriteError)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// getTenantConfigs returns the tenant configs from the "tenants-file" if provided,
+// otherwise "tenants" tenants with the same region configs.
+// Tenants in the tenants file without regions have the regions from "regions-file" or "regions".
+func getTenantConfigs() ([]generator.TenantConfig, error) {
+	var configs []generator.TenantConfig
+	if fTenantsFile != "" {
+		b, err := ioutil.ReadFile(fTenantsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tenants file: %w", err)
+		}
+		if err := json.Unmarshal(b, &configs); err != nil {
+			return nil, fmt.Errorf("failed to decode tenants file: %w", err)
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("no tenant found in tenants file")
+		}
+	} else {
+		if fNoOfTenants <= 0 {
+			return nil, fmt.Errorf("number of tenants must be positive")
+		}
+		configs = make([]generator.TenantConfig, fNoOfTenants)
+	}
+
+	for i := range configs {
+		c := &configs[i]
+		if len(c.Regions) > 0 {
+			for j := range c.Regions {
+				if c.Regions[j].Sites == 0 {
+					c.Regions[j].Sites = fNoOfSites
+				}
+			}
+			continue
+		}
+		regions, err := getRegionConfigs()
+		if err != nil {
+			return nil, fmt.Errorf("invalid regions of tenant %q: %w", c.Name, err)
+		}
+		c.Regions = regions
+	}
+	return configs, nil
+}
+
+// loadStaticTenants decodes tenants from the static JSON file.
+// Static files written before tenants were introduced only contain the list of regions (or sites),
+// these regions are put into a single default tenant.
+func loadStaticTenants(b []byte) ([]*model.Tenant, error) {
+	var tenants []*model.Tenant
+	if err := json.Unmarshal(b, &tenants); err != nil {
+		return nil, err
+	}
+	for _, t := range tenants {
+		if len(t.Regions) > 0 {
+			return tenants, nil
+		}
+	}
+
+	regions, err := loadStaticRegions(b)
+	if err != nil {
+		return nil, err
+	}
+	tenant := &model.Tenant{
+		Id:      fake.UUID(),
+		Name:    "Tenant#Default",
+		Slug:    "default",
+		Status:  model.StatusActive,
+		Regions: regions,
+	}
+	for _, r := range regions {
+		r.TenantId = tenant.Id
+		for _, site := range r.Sites {
+			site.TenantId = tenant.Id
+		}
+	}
+	return []*model.Tenant{tenant}, nil
+}
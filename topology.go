@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	fake "github.com/brianvoe/gofakeit/v6"
+	"github.com/lnquy/quest-ei/pkg/bandplan"
+	"github.com/lnquy/quest-ei/pkg/generator"
+	"github.com/lnquy/quest-ei/pkg/model"
+)
+
+// topologyFile is a CSV file of the topology directory and the fields read from it.
+type topologyFile struct {
+	name     string
+	required []string
+	optional []string
+}
+
+var (
+	topologySites      = topologyFile{name: "sites", required: []string{"id", "name"}, optional: []string{"region", "tenant", "status", "poor"}}
+	topologyChannels   = topologyFile{name: "channels", required: []string{"id", "site_id", "name"}, optional: []string{"tx_freq", "rx_freq", "status"}}
+	topologyFleets     = topologyFile{name: "fleets", required: []string{"id", "site_id", "name"}, optional: []string{"status"}}
+	topologyTalkGroups = topologyFile{name: "talk_groups", required: []string{"id", "site_id", "fleet_id", "name"}, optional: []string{"status"}}
+	topologyUnits      = topologyFile{name: "units", required: []string{"id", "site_id", "talk_group_id", "name"}, optional: []string{"status"}}
+	topologyConsoles   = topologyFile{name: "consoles", required: []string{"id", "site_id", "fleet_id", "name"}, optional: []string{"status"}}
+)
+
+// csvMapping maps the fields of a topology file to the columns of the CSV file.
+// Fields without mapping are read from the column with the same name as the field.
+type csvMapping struct {
+	File      string            `json:"file"`      // Defaults to <name>.csv
+	Delimiter string            `json:"delimiter"` // Defaults to ","
+	Columns   map[string]string `json:"columns"`
+}
+
+// csvRecord is a CSV row by field name, line is the row's line number in the CSV file.
+type csvRecord struct {
+	values map[string]string
+	line   int
+}
+
+func (r csvRecord) get(field string) string {
+	return strings.TrimSpace(r.values[field])
+}
+
+// loadTopology imports the tenants, regions, sites, channels, fleets, talk groups, units and consoles
+// from the CSV files in the "in-topology-dir" directory, with the column mapping from the "topology-mapping-file".
+// Consoles are optional and generated with "consoles-per-fleet" when consoles.csv doesn't exist.
+func loadTopology(t *generator.TopologyGenerator) ([]*model.Tenant, error) {
+	mappings := make(map[string]csvMapping)
+	if fTopologyMappingFile != "" {
+		b, err := ioutil.ReadFile(fTopologyMappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read topology mapping file: %w", err)
+		}
+		if err := json.Unmarshal(b, &mappings); err != nil {
+			return nil, fmt.Errorf("failed to decode topology mapping file: %w", err)
+		}
+	}
+
+	records := make(map[string][]csvRecord)
+	for _, tf := range []topologyFile{topologySites, topologyChannels, topologyFleets, topologyTalkGroups, topologyUnits, topologyConsoles} {
+		rs, err := readTopologyFile(tf, mappings[tf.name])
+		if errors.Is(err, os.ErrNotExist) && tf.name == topologyConsoles.name {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records[tf.name] = rs
+	}
+	_, hasConsoles := records[topologyConsoles.name]
+
+	return buildTopology(t, records, hasConsoles)
+}
+
+// readTopologyFile reads all rows of a topology CSV file.
+func readTopologyFile(tf topologyFile, m csvMapping) ([]csvRecord, error) {
+	file := m.File
+	if file == "" {
+		file = tf.name + ".csv"
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(fInTopologyDir, file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s topology file: %w", tf.name, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		r.Comma = []rune(m.Delimiter)[0]
+	}
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %w", file, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		columns[strings.TrimSpace(h)] = i
+	}
+
+	// Index of the CSV column of each field, -1 for missing optional fields
+	all := append(append([]string{}, tf.required...), tf.optional...)
+	fields := make(map[string]int, len(all))
+	for _, field := range all {
+		column := field
+		if c, ok := m.Columns[field]; ok {
+			column = c
+		}
+		idx, ok := columns[column]
+		if !ok {
+			idx = -1
+		}
+		fields[field] = idx
+	}
+	// Mapped columns must exist, so a typo in the mapping isn't silently read as an empty field
+	mapped := make([]string, 0, len(m.Columns))
+	for field := range m.Columns {
+		mapped = append(mapped, field)
+	}
+	sort.Strings(mapped)
+	for _, field := range mapped {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("%s: mapping of unknown field %q, fields are: %s", file, field, strings.Join(all, ", "))
+		}
+		if _, ok := columns[m.Columns[field]]; !ok {
+			return nil, fmt.Errorf("%s: missing column %q mapped to field %q", file, m.Columns[field], field)
+		}
+	}
+	for _, field := range tf.required {
+		if fields[field] < 0 {
+			return nil, fmt.Errorf("%s: missing column for required field %q", file, field)
+		}
+	}
+
+	records := make([]csvRecord, 0)
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		values := make(map[string]string, len(fields))
+		for field, idx := range fields {
+			if idx >= 0 && idx < len(row) {
+				values[field] = row[idx]
+			}
+		}
+		records = append(records, csvRecord{values: values, line: line})
+	}
+	return records, nil
+}
+
+// topologyErrors collects the referential integrity errors of the imported topology.
+type topologyErrors []string
+
+func (e *topologyErrors) add(file string, r csvRecord, format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf("%s.csv:%d: %s", file, r.line, fmt.Sprintf(format, args...)))
+}
+
+func (e topologyErrors) err() error {
+	return generator.ProblemsError("topology integrity errors", e)
+}
+
+// buildTopology builds the tenants from the CSV records and validates the referential integrity between them:
+//   - IDs are unique and every referenced site, fleet and talk group exists.
+//   - Talk groups, units and consoles belong to the same site as their fleet or talk group.
+func buildTopology(t *generator.TopologyGenerator, records map[string][]csvRecord, hasConsoles bool) ([]*model.Tenant, error) {
+	var errs topologyErrors
+
+	// Tenants and regions are identified by name, sites without them go to the default ones
+	tenants := make([]*model.Tenant, 0)
+	tenantMap := make(map[string]*model.Tenant)
+	slugs := make(map[string]bool)
+	regionMap := make(map[string]*model.Region)
+	siteMap := make(map[string]*model.Site)
+	for _, r := range records[topologySites.name] {
+		id := r.get("id")
+		if id == "" {
+			errs.add(topologySites.name, r, "empty id")
+			continue
+		}
+		if siteMap[id] != nil {
+			errs.add(topologySites.name, r, "duplicated site id %q", id)
+			continue
+		}
+
+		tenantName := r.get("tenant")
+		if tenantName == "" {
+			tenantName = "Default"
+		}
+		tenant := tenantMap[tenantName]
+		if tenant == nil {
+			tenant = &model.Tenant{
+				Id:     fake.UUID(),
+				Name:   tenantName,
+				Slug:   generator.Slug(tenantName),
+				Status: model.StatusActive,
+			}
+			if tenant.Slug == "" {
+				tenant.Slug = "tenant_" + strconv.Itoa(len(tenants)+1)
+			} else if slugs[tenant.Slug] { // Slug must be unique to route tenants to their own tables or files
+				tenant.Slug += "_" + strconv.Itoa(len(tenants)+1)
+			}
+			slugs[tenant.Slug] = true
+			tenantMap[tenantName] = tenant
+			tenants = append(tenants, tenant)
+		}
+		regionName := r.get("region")
+		if regionName == "" {
+			regionName = "Default"
+		}
+		region := regionMap[tenantName+"/"+regionName]
+		if region == nil {
+			region = &model.Region{
+				Id:       fake.UUID(),
+				TenantId: tenant.Id,
+				Name:     regionName,
+				Status:   model.StatusActive,
+			}
+			regionMap[tenantName+"/"+regionName] = region
+			tenant.Regions = append(tenant.Regions, region)
+		}
+
+		site := &model.Site{
+			Id:       id,
+			TenantId: tenant.Id,
+			RegionId: region.Id,
+			Name:     r.get("name"),
+			Status:   parseTopologyStatus(&errs, topologySites.name, r),
+		}
+		if poor := r.get("poor"); poor != "" {
+			var err error
+			if site.Poor, err = strconv.ParseBool(poor); err != nil {
+				errs.add(topologySites.name, r, "invalid poor value %q", poor)
+			}
+		}
+		siteMap[id] = site
+		region.Sites = append(region.Sites, site)
+	}
+
+	// siteOf returns the referenced site of the record, or nil if the site doesn't exist
+	siteOf := func(file string, r csvRecord) *model.Site {
+		site := siteMap[r.get("site_id")]
+		if site == nil {
+			errs.add(file, r, "site %q not found", r.get("site_id"))
+		}
+		return site
+	}
+	// isNewId returns true if the id of the record is not empty and not duplicated
+	ids := make(map[string]bool)
+	isNewId := func(file string, r csvRecord) bool {
+		id := r.get("id")
+		if id == "" {
+			errs.add(file, r, "empty id")
+			return false
+		}
+		if ids[file+"/"+id] {
+			errs.add(file, r, "duplicated id %q", id)
+			return false
+		}
+		ids[file+"/"+id] = true
+		return true
+	}
+
+	// Channels, frequencies missing in the CSV file are allocated from the band plan once all channels are read,
+	// so they don't duplicate the frequencies imported for other channels of the same site
+	type unallocatedChannel struct {
+		*model.Channel
+		site *model.Site
+		r    csvRecord
+	}
+	unallocated := make([]unallocatedChannel, 0)
+	imported := make(map[string][]float64) // Imported TX frequencies by site ID
+	for _, r := range records[topologyChannels.name] {
+		site := siteOf(topologyChannels.name, r)
+		if !isNewId(topologyChannels.name, r) || site == nil {
+			continue
+		}
+		channel := &model.Channel{
+			Id:     r.get("id"),
+			SiteId: site.Id,
+			Name:   r.get("name"),
+			Status: parseTopologyStatus(&errs, topologyChannels.name, r),
+		}
+		txFreq, rxFreq := r.get("tx_freq"), r.get("rx_freq")
+		if txFreq == "" || rxFreq == "" {
+			unallocated = append(unallocated, unallocatedChannel{Channel: channel, site: site, r: r})
+		} else {
+			var txErr, rxErr error
+			channel.TxFrequency, txErr = strconv.ParseFloat(txFreq, 64)
+			channel.RxFrequency, rxErr = strconv.ParseFloat(rxFreq, 64)
+			if txErr != nil || rxErr != nil {
+				errs.add(topologyChannels.name, r, "invalid frequencies tx=%q, rx=%q", txFreq, rxFreq)
+			}
+			imported[site.Id] = append(imported[site.Id], channel.TxFrequency)
+		}
+		site.Channels = append(site.Channels, channel)
+	}
+	frequencies := make(map[string]*bandplan.Allocator)
+	for _, c := range unallocated {
+		if frequencies[c.site.Id] == nil {
+			frequencies[c.site.Id] = t.NewFrequencyAllocator()
+			for _, txFreq := range imported[c.site.Id] {
+				frequencies[c.site.Id].Reserve(txFreq)
+			}
+		}
+		var err error
+		if c.TxFrequency, c.RxFrequency, err = frequencies[c.site.Id].Allocate(); err != nil {
+			errs.add(topologyChannels.name, c.r, "failed to allocate frequencies: %v", err)
+		}
+	}
+
+	// Fleets
+	fleetMap := make(map[string]*model.Fleet)
+	for _, r := range records[topologyFleets.name] {
+		site := siteOf(topologyFleets.name, r)
+		if !isNewId(topologyFleets.name, r) || site == nil {
+			continue
+		}
+		fleet := &model.Fleet{
+			Id:     r.get("id"),
+			SiteId: site.Id,
+			Name:   r.get("name"),
+			Status: parseTopologyStatus(&errs, topologyFleets.name, r),
+		}
+		fleetMap[fleet.Id] = fleet
+		site.Fleets = append(site.Fleets, fleet)
+	}
+
+	// fleetOf returns the referenced fleet of the record, or nil if the fleet doesn't exist on the record's site
+	fleetOf := func(file string, r csvRecord, site *model.Site) *model.Fleet {
+		fleet := fleetMap[r.get("fleet_id")]
+		if fleet == nil {
+			errs.add(file, r, "fleet %q not found", r.get("fleet_id"))
+			return nil
+		}
+		if fleet.SiteId != site.Id {
+			errs.add(file, r, "fleet %q belongs to site %q instead of %q", fleet.Id, fleet.SiteId, site.Id)
+			return nil
+		}
+		return fleet
+	}
+
+	// Talk groups
+	talkGroupMap := make(map[string]*model.TalkGroup)
+	for _, r := range records[topologyTalkGroups.name] {
+		site := siteOf(topologyTalkGroups.name, r)
+		if !isNewId(topologyTalkGroups.name, r) || site == nil {
+			continue
+		}
+		fleet := fleetOf(topologyTalkGroups.name, r, site)
+		if fleet == nil {
+			continue
+		}
+		talkGroup := &model.TalkGroup{
+			Id:      r.get("id"),
+			SiteId:  site.Id,
+			FleetId: fleet.Id,
+			Name:    r.get("name"),
+			Status:  parseTopologyStatus(&errs, topologyTalkGroups.name, r),
+		}
+		talkGroupMap[talkGroup.Id] = talkGroup
+		site.TalkGroups = append(site.TalkGroups, talkGroup)
+	}
+
+	// Units
+	for _, r := range records[topologyUnits.name] {
+		site := siteOf(topologyUnits.name, r)
+		if !isNewId(topologyUnits.name, r) || site == nil {
+			continue
+		}
+		talkGroup := talkGroupMap[r.get("talk_group_id")]
+		if talkGroup == nil {
+			errs.add(topologyUnits.name, r, "talk group %q not found", r.get("talk_group_id"))
+			continue
+		}
+		if talkGroup.SiteId != site.Id {
+			errs.add(topologyUnits.name, r, "talk group %q belongs to site %q instead of %q", talkGroup.Id, talkGroup.SiteId, site.Id)
+			continue
+		}
+		site.Units = append(site.Units, &model.Unit{
+			Id:          r.get("id"),
+			SiteId:      site.Id,
+			TalkGroupId: talkGroup.Id,
+			Name:        r.get("name"),
+			Status:      parseTopologyStatus(&errs, topologyUnits.name, r),
+		})
+	}
+
+	// Consoles
+	for _, r := range records[topologyConsoles.name] {
+		site := siteOf(topologyConsoles.name, r)
+		if !isNewId(topologyConsoles.name, r) || site == nil {
+			continue
+		}
+		fleet := fleetOf(topologyConsoles.name, r, site)
+		if fleet == nil {
+			continue
+		}
+		site.Consoles = append(site.Consoles, &model.Console{
+			Id:      r.get("id"),
+			SiteId:  site.Id,
+			FleetId: fleet.Id,
+			Name:    r.get("name"),
+			Status:  parseTopologyStatus(&errs, topologyConsoles.name, r),
+		})
+	}
+
+	if !hasConsoles {
+		for _, site := range generator.Sites(tenants) {
+			site.Consoles = t.NewConsoles(site.Id, site.Fleets, fNoOfConsolesPerFleet)
+		}
+	}
+	if len(siteMap) == 0 {
+		return nil, fmt.Errorf("no site found in topology files")
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// parseTopologyStatus returns the status of the record, or model.StatusActive if the status is not provided.
+func parseTopologyStatus(errs *topologyErrors, file string, r csvRecord) model.Status {
+	status := r.get("status")
+	if status == "" {
+		return model.StatusActive
+	}
+	s, err := strconv.ParseInt(status, 10, 64)
+	if err != nil {
+		errs.add(file, r, "invalid status %q", status)
+	}
+	return s
+}